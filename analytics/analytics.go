@@ -0,0 +1,87 @@
+// Package analytics rolls raw download events up into per-file daily tables
+// and holds the SQL used to classify them.
+package analytics
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/basit/fileshare-backend/models"
+)
+
+// Breakdown dimensions stored in file_daily_breakdowns.
+const (
+	DimUserAgent = "user_agent"
+	DimBrowser   = "browser"
+	DimOS        = "os"
+	DimReferrer  = "referrer"
+	DimCountry   = "country"
+	DimCity      = "city"
+	DimDevice    = "device"
+)
+
+// DownloaderExpr identifies a downloader: the user if signed in, otherwise
+// the client IP (possibly truncated or hashed). Anonymised events have
+// neither and aren't counted as unique downloaders.
+const DownloaderExpr = "COALESCE(user_id::text, NULLIF(ip_address, ''))"
+
+// Dimensions maps each breakdown dimension to the SQL expression that
+// derives it from a download_events row.
+var Dimensions = map[string]string{
+	DimUserAgent: userAgentExpr,
+	DimBrowser:   browserExpr,
+	DimOS:        osExpr,
+	DimReferrer:  referrerExpr,
+	DimCountry:   `COALESCE(NULLIF(country, ''), 'Unknown')`,
+	DimCity:      `COALESCE(NULLIF(city, ''), 'Unknown')`,
+	DimDevice:    `COALESCE(NULLIF(device, ''), 'unknown')`,
+}
+
+// Breakdown values are part of the file_daily_breakdowns primary key, so the
+// free-form ones are cut to 256 characters to stay within the btree limit.
+const userAgentExpr = `COALESCE(NULLIF(left(user_agent, 256), ''), 'Unknown')`
+
+// Browser and OS come from the columns filled in at ingest. The CASE
+// fallbacks classify events recorded before enrichment, and are order
+// sensitive: Edge and Opera also claim to be Chrome, Chrome claims to be
+// Safari, Android claims Linux and iOS claims Mac OS X.
+const browserExpr = `CASE
+	WHEN COALESCE(browser, '') <> '' THEN browser
+	WHEN COALESCE(user_agent, '') = '' THEN 'Unknown'
+	WHEN user_agent ~* '(bot|crawler|spider|curl|wget|python-requests|go-http-client)' THEN 'Bot'
+	WHEN user_agent ILIKE '%Edg/%' THEN 'Edge'
+	WHEN user_agent ILIKE '%OPR/%' OR user_agent ILIKE '%Opera%' THEN 'Opera'
+	WHEN user_agent ILIKE '%Firefox/%' OR user_agent ILIKE '%FxiOS/%' THEN 'Firefox'
+	WHEN user_agent ILIKE '%Chrome/%' OR user_agent ILIKE '%CriOS/%' THEN 'Chrome'
+	WHEN user_agent ILIKE '%Safari/%' THEN 'Safari'
+	ELSE 'Other'
+END`
+
+const osExpr = `CASE
+	WHEN COALESCE(os, '') <> '' THEN os
+	WHEN COALESCE(user_agent, '') = '' THEN 'Unknown'
+	WHEN user_agent ILIKE '%Windows%' THEN 'Windows'
+	WHEN user_agent ILIKE '%Android%' THEN 'Android'
+	WHEN user_agent ILIKE '%iPhone%' OR user_agent ILIKE '%iPad%' THEN 'iOS'
+	WHEN user_agent ILIKE '%Mac OS X%' OR user_agent ILIKE '%Macintosh%' THEN 'macOS'
+	WHEN user_agent ILIKE '%CrOS%' THEN 'ChromeOS'
+	WHEN user_agent ILIKE '%Linux%' THEN 'Linux'
+	ELSE 'Other'
+END`
+
+// referrerExpr groups referrers by host.
+const referrerExpr = `COALESCE(NULLIF(left(substring(referrer from '^[A-Za-z][A-Za-z0-9+.-]*://([^/:?#]+)'), 256), ''), '(direct)')`
+
+// DeleteFileData removes the raw events and rollups of the given files.
+func DeleteFileData(tx *gorm.DB, fileIDs interface{}) error {
+	for _, model := range []interface{}{
+		&models.DownloadEvent{},
+		&models.FileDailyStat{},
+		&models.FileDailyDownloader{},
+		&models.FileDailyBreakdown{},
+	} {
+		if err := tx.Where("file_id IN ?", fileIDs).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
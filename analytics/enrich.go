@@ -0,0 +1,92 @@
+package analytics
+
+import (
+	"log"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/mileusna/useragent"
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/basit/fileshare-backend/models"
+)
+
+// Device classes stored on download events.
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+	DeviceUnknown = "unknown"
+)
+
+// GEOIP_DB_PATH points at a MaxMind-format database such as GeoLite2-City
+// or GeoLite2-Country. Without it events are recorded without a location.
+var (
+	geoOnce   sync.Once
+	geoReader *maxminddb.Reader
+)
+
+// geoRecord is the part of a City or Country database record we keep.
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+func geoDB() *maxminddb.Reader {
+	geoOnce.Do(func() {
+		path := os.Getenv("GEOIP_DB_PATH")
+		if path == "" {
+			return
+		}
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			log.Printf("⚠️  GeoIP database %s not loaded: %v", path, err)
+			return
+		}
+		log.Printf("✅ GeoIP database loaded: %s", reader.Metadata.DatabaseType)
+		geoReader = reader
+	})
+	return geoReader
+}
+
+// Enrich fills in an event's location from its IP address and its browser,
+// OS and device from its user agent. It must run before the IP address is
+// anonymised.
+func Enrich(event *models.DownloadEvent) {
+	if db := geoDB(); db != nil {
+		if ip := net.ParseIP(event.IPAddress); ip != nil {
+			var record geoRecord
+			if err := db.Lookup(ip, &record); err == nil {
+				event.Country = record.Country.ISOCode
+				event.City = record.City.Names["en"]
+			}
+		}
+	}
+
+	if event.UserAgent == "" {
+		event.Device = DeviceUnknown
+		return
+	}
+	ua := useragent.Parse(event.UserAgent)
+	event.Browser = ua.Name
+	event.OS = ua.OS
+	event.IsBot = ua.Bot
+	switch {
+	case ua.Bot:
+		event.Device = DeviceBot
+	case ua.Tablet:
+		event.Device = DeviceTablet
+	case ua.Mobile:
+		event.Device = DeviceMobile
+	case ua.Desktop:
+		event.Device = DeviceDesktop
+	default:
+		event.Device = DeviceUnknown
+	}
+}
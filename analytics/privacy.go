@@ -0,0 +1,259 @@
+package analytics
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/basit/fileshare-backend/config"
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+)
+
+// IPMode is how client IPs are stored on download events.
+type IPMode string
+
+const (
+	// IPFull stores the address as received.
+	IPFull IPMode = "full"
+	// IPTruncate zeroes the host part: IPv4 to /24, IPv6 to /48.
+	IPTruncate IPMode = "truncate"
+	// IPHash stores a keyed hash. The key rotates, so the same address
+	// hashes differently in each period and old hashes can't be reversed
+	// once their key is deleted.
+	IPHash IPMode = "hash"
+)
+
+// Privacy settings, read from the environment once:
+//
+//	DOWNLOAD_IP_MODE            full (default), truncate or hash
+//	DOWNLOAD_IP_SALT_ROTATION   how long a hash key is used, default 24h
+var (
+	ipMode         = parseIPMode(os.Getenv("DOWNLOAD_IP_MODE"))
+	saltRotation   = config.Duration("DOWNLOAD_IP_SALT_ROTATION", 24*time.Hour)
+	anonymizeBatch = 10000
+)
+
+func parseIPMode(value string) IPMode {
+	switch IPMode(value) {
+	case "", IPFull:
+		return IPFull
+	case IPTruncate, IPHash:
+		return IPMode(value)
+	}
+	log.Printf("⚠️  Ignoring DOWNLOAD_IP_MODE=%q, storing full IPs", value)
+	return IPFull
+}
+
+var (
+	saltMu     sync.Mutex
+	saltPeriod time.Time
+	saltKey    []byte
+)
+
+// currentSalt returns the hash key for the current period, creating it if
+// no instance has yet. Every instance shares the key through the database.
+func currentSalt() ([]byte, error) {
+	period := time.Now().UTC().Truncate(saltRotation)
+
+	saltMu.Lock()
+	defer saltMu.Unlock()
+	if period.Equal(saltPeriod) {
+		return saltKey, nil
+	}
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if err := initializers.DB.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.IPHashSalt{PeriodStart: period, Salt: salt}).Error; err != nil {
+		return nil, err
+	}
+	var stored models.IPHashSalt
+	if err := initializers.DB.First(&stored, "period_start = ?", period).Error; err != nil {
+		return nil, err
+	}
+
+	saltPeriod, saltKey = period, stored.Salt
+	return saltKey, nil
+}
+
+// AnonymizeIP applies the configured IP mode to an address.
+func AnonymizeIP(address string) (string, error) {
+	switch ipMode {
+	case IPTruncate:
+		return truncateIP(address), nil
+	case IPHash:
+		if address == "" {
+			return "", nil
+		}
+		salt, err := currentSalt()
+		if err != nil {
+			return "", fmt.Errorf("error loading IP hash key: %v", err)
+		}
+		mac := hmac.New(sha256.New, salt)
+		mac.Write([]byte(address))
+		return "h:" + hex.EncodeToString(mac.Sum(nil)[:16]), nil
+	}
+	return address, nil
+}
+
+func truncateIP(address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// stripDetails removes everything that identifies a downloader, keeping
+// only the coarse country and client classification.
+func stripDetails(event *models.DownloadEvent, now time.Time) {
+	event.IPAddress = ""
+	event.UserAgent = ""
+	event.Referrer = ""
+	event.City = ""
+	event.UserID = nil
+	event.AnonymizedAt = &now
+}
+
+// AnonymizeEvents strips identifying details from download events older
+// than after, and from the rollups of the days before then. Days the rollup
+// hasn't finished are left alone. It returns the number of events changed.
+func AnonymizeEvents(ctx context.Context, after time.Duration) (int, error) {
+	if after < MinRetention {
+		after = MinRetention
+	}
+	cutoff := time.Now().Add(-after)
+
+	// Days the rollup may still recompute keep their details until it has
+	// finished with them.
+	finished, ok, err := rolledUpBefore()
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, nil
+	}
+	if cutoff.After(finished) {
+		cutoff = finished
+	}
+
+	total := 0
+	for {
+		if ctx.Err() != nil {
+			return total, ctx.Err()
+		}
+		result := initializers.DB.Exec(`
+UPDATE download_events
+SET ip_address = '', user_agent = '', referrer = NULL, city = NULL, user_id = NULL, anonymized_at = ?
+WHERE id IN (
+	SELECT id FROM download_events
+	WHERE created_at < ? AND anonymized_at IS NULL
+	LIMIT ?
+)`, time.Now(), cutoff, anonymizeBatch)
+		if result.Error != nil {
+			return total, fmt.Errorf("error anonymising download events: %v", result.Error)
+		}
+		total += int(result.RowsAffected)
+		if result.RowsAffected < int64(anonymizeBatch) {
+			break
+		}
+	}
+
+	if err := anonymizeRollups(cutoff); err != nil {
+		return total, err
+	}
+
+	// Hashes from past periods can no longer be tied to an address.
+	period := time.Now().UTC().Truncate(saltRotation)
+	if err := initializers.DB.Where("period_start < ?", period).Delete(&models.IPHashSalt{}).Error; err != nil {
+		return total, fmt.Errorf("error deleting old IP hash keys: %v", err)
+	}
+
+	return total, nil
+}
+
+// anonymizeRollups scrubs the rollups of every day before cutoff that hasn't
+// been scrubbed yet: breakdowns by user agent, referrer and city are
+// dropped, as they are from the events, and so are the per-downloader rows.
+// Those days keep only their daily unique count in file_daily_stats.
+func anonymizeRollups(cutoff time.Time) error {
+	end := Day(cutoff)
+
+	var state models.RollupState
+	if err := initializers.DB.Where("name = ?", anonymizeName).Limit(1).Find(&state).Error; err != nil {
+		return fmt.Errorf("error loading rollup anonymisation state: %v", err)
+	}
+	if state.Name != "" && !Day(state.CompleteThrough).Before(end) {
+		return nil
+	}
+
+	return initializers.DB.Transaction(func(tx *gorm.DB) error {
+		scope := func(db *gorm.DB) *gorm.DB {
+			db = db.Where("day < ?", end.Format(dayFormat))
+			if state.Name != "" {
+				db = db.Where("day >= ?", Day(state.CompleteThrough).Format(dayFormat))
+			}
+			return db
+		}
+
+		if err := tx.Scopes(scope).
+			Where("dimension IN ?", []string{DimUserAgent, DimReferrer, DimCity}).
+			Delete(&models.FileDailyBreakdown{}).Error; err != nil {
+			return fmt.Errorf("error anonymising download breakdowns: %v", err)
+		}
+
+		if err := tx.Scopes(scope).Delete(&models.FileDailyDownloader{}).Error; err != nil {
+			return fmt.Errorf("error anonymising downloaders: %v", err)
+		}
+
+		state = models.RollupState{Name: anonymizeName, CompleteThrough: end}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"complete_through", "updated_at"}),
+		}).Create(&state).Error
+	})
+}
+
+// AnonymizedBefore returns the first day whose rollups still list individual
+// downloaders. Days before it only have a daily unique count. It is the zero
+// time if nothing has been anonymised.
+func AnonymizedBefore() (time.Time, error) {
+	var state models.RollupState
+	if err := initializers.DB.Where("name = ?", anonymizeName).Limit(1).Find(&state).Error; err != nil {
+		return time.Time{}, fmt.Errorf("error loading rollup anonymisation state: %v", err)
+	}
+	if state.Name == "" {
+		return time.Time{}, nil
+	}
+	return Day(state.CompleteThrough), nil
+}
+
+// downloaderKey keys the downloader hashes in the rollups. It never rotates,
+// so a downloader hashes the same on every day and can be counted once over
+// a range. Set with DOWNLOADER_HASH_SECRET; by default it is derived from
+// JWT_SECRET. Changing it makes downloaders on either side count twice.
+func downloaderKey() string {
+	if secret := os.Getenv("DOWNLOADER_HASH_SECRET"); secret != "" {
+		return secret
+	}
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	mac.Write([]byte("downloader-hash"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,266 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/basit/fileshare-backend/config"
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+)
+
+// Recorder settings, read from the environment once:
+//
+//	DOWNLOAD_EVENT_QUEUE_SIZE      events buffered before new ones are dropped, default 10000
+//	DOWNLOAD_EVENT_BATCH_SIZE      events written per batch, default 500
+//	DOWNLOAD_EVENT_FLUSH_INTERVAL  longest an event waits to be written, default 2s
+var Events = NewRecorder(
+	config.Int("DOWNLOAD_EVENT_QUEUE_SIZE", 10000),
+	config.Int("DOWNLOAD_EVENT_BATCH_SIZE", 500),
+	config.Duration("DOWNLOAD_EVENT_FLUSH_INTERVAL", 2*time.Second),
+)
+
+// flushAttempts is how many times a batch is tried before it is dropped.
+const flushAttempts = 3
+
+// Recorder buffers download events in memory and writes them in batches,
+// together with the download_count and last_downloaded_at of their files.
+// Record never blocks: when the queue is full the event is dropped and
+// counted.
+type Recorder struct {
+	queue         chan models.DownloadEvent
+	batchSize     int
+	flushInterval time.Duration
+
+	recorded      atomic.Int64
+	dropped       atomic.Int64
+	failedBatches atomic.Int64
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// RecorderStats is a snapshot of a recorder's counters on this instance.
+type RecorderStats struct {
+	Queued        int
+	Capacity      int
+	Recorded      int64
+	Dropped       int64
+	FailedBatches int64
+}
+
+func NewRecorder(queueSize, batchSize int, flushInterval time.Duration) *Recorder {
+	return &Recorder{
+		queue:         make(chan models.DownloadEvent, queueSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+// Start runs the writer in the background. Call Stop to flush and end it.
+func (r *Recorder) Start() {
+	r.startOnce.Do(func() {
+		go r.run()
+	})
+}
+
+// Record queues an event. It reports false when the queue was full and the
+// event was dropped.
+func (r *Recorder) Record(event models.DownloadEvent) bool {
+	if event.ID == uuid.Nil {
+		event.ID = uuid.New()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	select {
+	case r.queue <- event:
+		return true
+	default:
+		if n := r.dropped.Add(1); n == 1 || n%1000 == 0 {
+			log.Printf("⚠️  Download event queue full, %d events dropped so far", n)
+		}
+		return false
+	}
+}
+
+// Stop writes whatever is queued and stops the writer. Events recorded
+// after Stop are dropped once the queue fills. It gives up when ctx ends.
+func (r *Recorder) Stop(ctx context.Context) error {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("download events not flushed: %w", ctx.Err())
+	}
+}
+
+func (r *Recorder) Stats() RecorderStats {
+	return RecorderStats{
+		Queued:        len(r.queue),
+		Capacity:      cap(r.queue),
+		Recorded:      r.recorded.Load(),
+		Dropped:       r.dropped.Load(),
+		FailedBatches: r.failedBatches.Load(),
+	}
+}
+
+func (r *Recorder) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.DownloadEvent, 0, r.batchSize)
+	for {
+		select {
+		case event := <-r.queue:
+			batch = append(batch, event)
+			if len(batch) >= r.batchSize {
+				r.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				r.flush(batch)
+				batch = batch[:0]
+			}
+		case <-r.stop:
+			for {
+				select {
+				case event := <-r.queue:
+					batch = append(batch, event)
+					if len(batch) >= r.batchSize {
+						r.flush(batch)
+						batch = batch[:0]
+					}
+				default:
+					if len(batch) > 0 {
+						r.flush(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+func (r *Recorder) flush(batch []models.DownloadEvent) {
+	var err error
+	for attempt := 1; attempt <= flushAttempts; attempt++ {
+		if err = writeEvents(batch); err == nil {
+			r.recorded.Add(int64(len(batch)))
+			return
+		}
+		time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+	}
+
+	r.failedBatches.Add(1)
+	r.dropped.Add(int64(len(batch)))
+	log.Printf("Failed to write %d download events, dropping them: %v", len(batch), err)
+}
+
+type fileCounter struct {
+	downloads int64
+	last      time.Time
+}
+
+// writeEvents enriches a batch, applies the privacy settings, then inserts
+// it and bumps the counters of its files in one transaction. Events for
+// files deleted since the download are skipped.
+func writeEvents(batch []models.DownloadEvent) error {
+	counters := make(map[uuid.UUID]*fileCounter)
+	for i := range batch {
+		Enrich(&batch[i])
+	}
+	for _, event := range batch {
+		counter, ok := counters[event.FileID]
+		if !ok {
+			counter = &fileCounter{}
+			counters[event.FileID] = counter
+		}
+		counter.downloads++
+		if event.CreatedAt.After(counter.last) {
+			counter.last = event.CreatedAt
+		}
+	}
+
+	ids := make([]uuid.UUID, 0, len(counters))
+	for id := range counters {
+		ids = append(ids, id)
+	}
+
+	return initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var files []struct {
+			ID       uuid.UUID
+			Detailed bool
+		}
+		if err := tx.Table("files").
+			Select("files.id, COALESCE(users.detailed_download_logs, true) AS detailed").
+			Joins("LEFT JOIN users ON users.id = files.user_id").
+			Where("files.id IN ?", ids).
+			Scan(&files).Error; err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return nil
+		}
+		existing := make([]uuid.UUID, 0, len(files))
+		detailed := make(map[uuid.UUID]bool, len(files))
+		for _, file := range files {
+			existing = append(existing, file.ID)
+			detailed[file.ID] = file.Detailed
+		}
+
+		now := time.Now()
+		events := make([]models.DownloadEvent, 0, len(batch))
+		for _, event := range batch {
+			isDetailed, ok := detailed[event.FileID]
+			if !ok {
+				continue
+			}
+			if isDetailed {
+				ip, err := AnonymizeIP(event.IPAddress)
+				if err != nil {
+					return err
+				}
+				event.IPAddress = ip
+			} else {
+				stripDetails(&event, now)
+			}
+			events = append(events, event)
+		}
+		if err := tx.Omit("File").Create(&events).Error; err != nil {
+			return err
+		}
+
+		values := make([]string, 0, len(existing))
+		args := make([]interface{}, 0, 3*len(existing))
+		for _, id := range existing {
+			values = append(values, "(CAST(? AS uuid), CAST(? AS bigint), CAST(? AS timestamptz))")
+			args = append(args, id, counters[id].downloads, counters[id].last)
+		}
+		return tx.Exec(`
+UPDATE files
+SET download_count = files.download_count + v.downloads,
+	last_downloaded_at = GREATEST(COALESCE(files.last_downloaded_at, v.last), v.last)
+FROM (VALUES `+strings.Join(values, ", ")+`) AS v(id, downloads, last)
+WHERE files.id = v.id`, args...).Error
+	})
+}
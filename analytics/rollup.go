@@ -0,0 +1,210 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+)
+
+const (
+	rollupName    = "download_events"
+	anonymizeName = "download_events_anonymized"
+	dayFormat     = "2006-01-02"
+
+	// purgeBatchSize bounds how many raw events one DELETE removes.
+	purgeBatchSize = 10000
+)
+
+// MinRetention is the shortest raw event retention allowed. Rollups
+// recompute the last finished day, so its events must still be there.
+const MinRetention = 48 * time.Hour
+
+// Day truncates t to the start of its UTC day.
+func Day(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// Rollup recomputes the daily rollups from the last finished day through
+// today, then marks every day before today as finished. On the first run it
+// starts from the oldest raw event. It returns the number of days rolled up.
+func Rollup(ctx context.Context) (int, error) {
+	today := Day(time.Now())
+
+	var state models.RollupState
+	err := initializers.DB.Where("name = ?", rollupName).Limit(1).Find(&state).Error
+	if err != nil {
+		return 0, fmt.Errorf("error loading rollup state: %v", err)
+	}
+
+	var start time.Time
+	if state.Name != "" {
+		// Recompute the last finished day too, for events recorded late.
+		start = Day(state.CompleteThrough).AddDate(0, 0, -1)
+	} else {
+		var oldest *time.Time
+		if err := initializers.DB.Model(&models.DownloadEvent{}).
+			Select("MIN(created_at)").
+			Scan(&oldest).Error; err != nil {
+			return 0, fmt.Errorf("error finding oldest download event: %v", err)
+		}
+		start = today
+		if oldest != nil {
+			start = Day(*oldest)
+		}
+	}
+
+	days := 0
+	for day := start; !day.After(today); day = day.AddDate(0, 0, 1) {
+		if ctx.Err() != nil {
+			return days, ctx.Err()
+		}
+		if err := RollupDay(day); err != nil {
+			return days, fmt.Errorf("error rolling up %s: %v", day.Format(dayFormat), err)
+		}
+		days++
+	}
+
+	state = models.RollupState{Name: rollupName, CompleteThrough: today}
+	if err := initializers.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"complete_through", "updated_at"}),
+	}).Create(&state).Error; err != nil {
+		return days, fmt.Errorf("error saving rollup state: %v", err)
+	}
+
+	return days, nil
+}
+
+// RollupDay replaces the rollups for one UTC day with aggregates of that
+// day's raw events.
+func RollupDay(day time.Time) error {
+	day = Day(day)
+	args := map[string]interface{}{
+		"day":   day.Format(dayFormat),
+		"start": day,
+		"end":   day.AddDate(0, 0, 1),
+		"key":   downloaderKey(),
+	}
+
+	return initializers.DB.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{
+			&models.FileDailyStat{},
+			&models.FileDailyDownloader{},
+			&models.FileDailyBreakdown{},
+		} {
+			if err := tx.Where("day = ?", args["day"]).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Exec(`
+INSERT INTO file_daily_stats (file_id, day, downloads, unique_downloaders)
+SELECT file_id, CAST(@day AS date), COUNT(*), COUNT(DISTINCT `+DownloaderExpr+`)
+FROM download_events
+WHERE created_at >= @start AND created_at < @end
+GROUP BY file_id`, args).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec(`
+INSERT INTO file_daily_downloaders (file_id, day, downloader_hash)
+SELECT DISTINCT file_id, CAST(@day AS date), md5(@key || `+DownloaderExpr+`)
+FROM download_events
+WHERE created_at >= @start AND created_at < @end
+	AND `+DownloaderExpr+` IS NOT NULL`, args).Error; err != nil {
+			return err
+		}
+
+		return tx.Exec(`
+INSERT INTO file_daily_breakdowns (file_id, day, dimension, value, count)
+`+breakdownSelect(), args).Error
+	})
+}
+
+// breakdownSelect builds one SELECT per dimension, joined with UNION ALL.
+func breakdownSelect() string {
+	names := make([]string, 0, len(Dimensions))
+	for name := range Dimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	selects := make([]string, 0, len(names))
+	for _, name := range names {
+		selects = append(selects, fmt.Sprintf(`SELECT file_id, CAST(@day AS date), '%s', %s, COUNT(*)
+FROM download_events
+WHERE created_at >= @start AND created_at < @end
+GROUP BY 1, 4`, name, Dimensions[name]))
+	}
+	return strings.Join(selects, "\nUNION ALL\n")
+}
+
+// rolledUpBefore returns the start of the oldest day the rollup may still
+// recompute. Raw events before it are no longer needed by the rollups. It
+// reports false if the rollup has never run.
+func rolledUpBefore() (time.Time, bool, error) {
+	var state models.RollupState
+	if err := initializers.DB.Where("name = ?", rollupName).Limit(1).Find(&state).Error; err != nil {
+		return time.Time{}, false, fmt.Errorf("error loading rollup state: %v", err)
+	}
+	if state.Name == "" {
+		return time.Time{}, false, nil
+	}
+	return Day(state.CompleteThrough).AddDate(0, 0, -1), true, nil
+}
+
+// FinalBefore returns the start of the oldest day whose rollup may still
+// change. Counts from then on are read from the raw events, which are kept
+// until the rollup is final. It is the zero time if the rollup has never
+// run, when every event is still raw.
+func FinalBefore() (time.Time, error) {
+	day, _, err := rolledUpBefore()
+	return day, err
+}
+
+// PurgeEvents deletes raw download events older than retention, but never
+// from days that haven't been rolled up yet. It returns the number deleted.
+func PurgeEvents(ctx context.Context, retention time.Duration) (int, error) {
+	if retention < MinRetention {
+		retention = MinRetention
+	}
+	cutoff := time.Now().Add(-retention)
+
+	finished, ok, err := rolledUpBefore()
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, nil
+	}
+	if cutoff.After(finished) {
+		cutoff = finished
+	}
+
+	total := 0
+	for {
+		if ctx.Err() != nil {
+			return total, ctx.Err()
+		}
+		result := initializers.DB.Exec(`
+DELETE FROM download_events
+WHERE id IN (
+	SELECT id FROM download_events WHERE created_at < ? LIMIT ?
+)`, cutoff, purgeBatchSize)
+		if result.Error != nil {
+			return total, fmt.Errorf("error purging download events: %v", result.Error)
+		}
+		total += int(result.RowsAffected)
+		if result.RowsAffected < purgeBatchSize {
+			return total, nil
+		}
+	}
+}
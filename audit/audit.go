@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+)
+
+const (
+	ActionLogin          = "auth.login"
+	ActionLoginFailed    = "auth.login_failed"
+	ActionTokenRefresh   = "auth.token_refresh"
+	ActionPasswordChange = "auth.password_change"
+	ActionPasswordReset  = "auth.password_reset"
+	ActionIdentityLink   = "auth.identity_link"
+	ActionIdentityUnlink = "auth.identity_unlink"
+	ActionAccountDelete  = "account.delete"
+	ActionPrivacyUpdate  = "account.privacy_update"
+	ActionDataExport     = "account.export"
+	ActionTakeoutRequest = "account.takeout_request"
+	ActionTakeoutFetch   = "account.takeout_download"
+
+	ActionFileUpload      = "file.upload"
+	ActionFileRename      = "file.rename"
+	ActionFileDelete      = "file.delete"
+	ActionFileShareUpdate = "file.share_update"
+	ActionFileExpired     = "file.expired"
+	ActionFileExtend      = "file.extend"
+
+	ActionFileIntegrityFailed = "file.integrity_failed"
+
+	ActionAdminSuspendUser   = "admin.user_suspend"
+	ActionAdminUnsuspendUser = "admin.user_unsuspend"
+	ActionAdminSetRole       = "admin.user_role"
+	ActionAdminExpireFile    = "admin.file_expire"
+	ActionAdminRunCleanup    = "admin.cleanup"
+	ActionAdminRunJob        = "admin.job_run"
+	ActionAdminReconcile     = "admin.reconcile_storage"
+)
+
+const (
+	TargetUser = "user"
+	TargetFile = "file"
+)
+
+// Event describes one audited action. Metadata is stored as JSON.
+type Event struct {
+	ActorID    *uuid.UUID
+	Action     string
+	TargetType string
+	TargetID   string
+	Metadata   map[string]interface{}
+}
+
+// Record stores e with the client IP and user agent of the request. Audit
+// failures are logged and never fail the action being audited.
+func Record(c *gin.Context, e Event) {
+	var ip, userAgent string
+	if c != nil {
+		ip = c.ClientIP()
+		userAgent = c.Request.UserAgent()
+	}
+	write(e, ip, userAgent)
+}
+
+// RecordFromContext is Record for GraphQL resolvers, which carry the gin
+// context inside ctx.
+func RecordFromContext(ctx context.Context, e Event) {
+	c, _ := ctx.Value("GinContextKey").(*gin.Context)
+	Record(c, e)
+}
+
+// RecordSystem stores an action taken by a background job.
+func RecordSystem(e Event) {
+	write(e, "", "")
+}
+
+func write(e Event, ip, userAgent string) {
+	metadata := "{}"
+	if len(e.Metadata) > 0 {
+		if b, err := json.Marshal(e.Metadata); err == nil {
+			metadata = string(b)
+		}
+	}
+
+	event := models.AuditEvent{
+		ActorID:    e.ActorID,
+		Action:     e.Action,
+		TargetType: e.TargetType,
+		TargetID:   e.TargetID,
+		IPAddress:  ip,
+		UserAgent:  userAgent,
+		Metadata:   metadata,
+	}
+	if err := initializers.DB.Create(&event).Error; err != nil {
+		log.Printf("Failed to record audit event %s: %v", e.Action, err)
+	}
+}
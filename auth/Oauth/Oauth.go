@@ -1,12 +1,15 @@
 package Oauth
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"time"
 
+	"github.com/basit/fileshare-backend/audit"
 	"github.com/basit/fileshare-backend/auth"
 	"github.com/basit/fileshare-backend/initializers"
 	"github.com/basit/fileshare-backend/models"
@@ -16,10 +19,13 @@ import (
 	"github.com/google/uuid"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
-	"github.com/markbates/goth/providers/google"
 	"gorm.io/gorm"
 )
 
+// ErrAccountExists is returned when a new provider identity's email belongs
+// to an existing account.
+var ErrAccountExists = errors.New("an account with this email already exists; sign in and link this provider from settings")
+
 type TokenResponse struct {
 	OauthAccessToken  string `json:"oauth_access_token"`
 	ExpiresIn         int    `json:"expires_in"`
@@ -45,25 +51,7 @@ func InitStore() {
 
 	gothic.Store = store
 
-	fmt.Println("GOOGLE_CLIENT_ID", os.Getenv("GOOGLE_CLIENT_ID"))
-	fmt.Println("GOOGLE_CLIENT_SECRET", os.Getenv("GOOGLE_CLIENT_SECRET"))
-	fmt.Println("GOOGLE_REDIRECT_URL", os.Getenv("GOOGLE_REDIRECT_URL"))
-	provider := google.New(
-		os.Getenv("GOOGLE_CLIENT_ID"),
-		os.Getenv("GOOGLE_CLIENT_SECRET"),
-		os.Getenv("GOOGLE_REDIRECT_URL"),
-		"https://www.googleapis.com/auth/calendar.events",
-		"https://www.googleapis.com/auth/gmail.readonly",
-		"https://www.googleapis.com/auth/gmail.send",
-		"email",
-		"profile",
-	)
-
-	// Force refresh token by adding extra auth parameters
-	provider.SetAccessType("offline") // Ensures refresh token is received
-	provider.SetPrompt("consent")
-
-	goth.UseProviders(provider)
+	registerProviders()
 }
 
 // Begin OAuth authentication
@@ -80,21 +68,52 @@ func OauthCallbackHandler(c *gin.Context) {
 		c.Request.URL.RawQuery = q.Encode()
 	}
 
-	// For GitHub, add scope to get user email (if public)
-	if provider == "github" {
-		q := c.Request.URL.Query()
-		q.Add("scope", "user:email") // Request email access
-		c.Request.URL.RawQuery = q.Encode()
+	// Callers never choose the OAuth state. A logged-in user linking another
+	// provider arrives with ?link=1 and the cookie set by startIdentityLink;
+	// their state is marked so the callback knows the flow began here.
+	q := c.Request.URL.Query()
+	q.Del("state")
+	q.Del("link_token")
+	if q.Get("link") == "1" {
+		if _, err := c.Request.Cookie(auth.IdentityLinkCookie); err == nil {
+			q.Set("state", auth.IdentityLinkStatePrefix+uuid.NewString())
+		}
 	}
+	q.Del("link")
 
 	// Goth expects the provider to be in the URL path
-	q := c.Request.URL.Query()
 	q.Add("provider", provider)
 	c.Request.URL.RawQuery = q.Encode()
 
 	gothic.BeginAuthHandler(c.Writer, c.Request)
 }
 
+// completeIdentityLink finishes the link flow started by startIdentityLink and
+// sends the browser back to the frontend's connected accounts page.
+func completeIdentityLink(c *gin.Context, linkToken string, gothUser goth.User) {
+	redirectURL := fmt.Sprintf("%s/settings/connected-accounts?linked=%s", os.Getenv("BASE_URL"), url.QueryEscape(gothUser.Provider))
+
+	token, err := auth.ConsumeUserToken(models.TokenPurposeIdentityLink, linkToken)
+	if err == nil {
+		err = linkExplicitIdentity(token.UserID, gothUser)
+	}
+	if err == nil {
+		audit.Record(c, audit.Event{
+			ActorID:    &token.UserID,
+			Action:     audit.ActionIdentityLink,
+			TargetType: audit.TargetUser,
+			TargetID:   token.UserID.String(),
+			Metadata:   map[string]interface{}{"provider": gothUser.Provider},
+		})
+	}
+	if err != nil {
+		log.Printf("Identity link error: %v", err)
+		redirectURL = fmt.Sprintf("%s/settings/connected-accounts?link_error=%s", os.Getenv("BASE_URL"), url.QueryEscape(err.Error()))
+	}
+
+	c.Redirect(http.StatusTemporaryRedirect, redirectURL)
+}
+
 // Complete OAuth authentication
 func CompleteAuth(c *gin.Context) {
 	// Add provider to query params for goth
@@ -102,6 +121,14 @@ func CompleteAuth(c *gin.Context) {
 	q.Add("provider", c.Param("provider"))
 	c.Request.URL.RawQuery = q.Encode()
 
+	// The link cookie is single-use whatever the outcome, so a leftover one
+	// can never divert a later login into the link flow.
+	var linkToken string
+	if cookie, err := c.Request.Cookie(auth.IdentityLinkCookie); err == nil {
+		linkToken = cookie.Value
+		auth.ClearIdentityLinkCookie(c.Writer)
+	}
+
 	gothUser, err := gothic.CompleteUserAuth(c.Writer, c.Request)
 	if err != nil {
 		log.Printf("Auth error: %v", err)
@@ -109,14 +136,30 @@ func CompleteAuth(c *gin.Context) {
 		return
 	}
 
+	// gothic has checked the state against the one stored when this browser
+	// began the flow, so the prefix proves the link flow started here.
+	if linkToken != "" && auth.IsIdentityLinkState(c.Query("state")) {
+		completeIdentityLink(c, linkToken, gothUser)
+		return
+	}
+
 	// Find or create user in database
 	user, err := findOrCreateOAuthUser(gothUser)
+	if errors.Is(err, ErrAccountExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists. Sign in and link " + gothUser.Provider + " from your account settings."})
+		return
+	}
 	if err != nil {
 		log.Printf("Database error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process user data"})
 		return
 	}
 
+	if user.IsSuspended() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account suspended"})
+		return
+	}
+
 	// Log OAuth user data
 	log.Printf("Provider: %s", gothUser.Provider)
 	log.Printf("User ID: %s", gothUser.UserID)
@@ -151,6 +194,14 @@ func CompleteAuth(c *gin.Context) {
 		// Don't fail the auth process for session errors
 	}
 
+	audit.Record(c, audit.Event{
+		ActorID:    &user.ID,
+		Action:     audit.ActionLogin,
+		TargetType: audit.TargetUser,
+		TargetID:   user.ID.String(),
+		Metadata:   map[string]interface{}{"method": gothUser.Provider},
+	})
+
 	log.Printf("OAuth authentication successful for user: %s", user.Email)
 
 	// Redirect to your frontend with the access token
@@ -161,133 +212,141 @@ func CompleteAuth(c *gin.Context) {
 }
 
 func findOrCreateOAuthUser(gothUser goth.User) (*models.User, error) {
-	var user models.User
-
-	// Try to find existing user by OAuth provider ID first
-	var err error
-	switch gothUser.Provider {
-	case "google":
-		err = initializers.DB.Where("google_id = ?", gothUser.UserID).First(&user).Error
-	case "github":
-		err = initializers.DB.Where("git_hub_id = ?", gothUser.UserID).First(&user).Error
-	default:
+	if !isBuiltinProvider(gothUser.Provider) && !oidcProviders[gothUser.Provider] {
 		return nil, fmt.Errorf("unsupported provider: %s", gothUser.Provider)
 	}
 
+	// Try to find existing user by OAuth provider ID first
+	var identity models.UserIdentity
+	err := initializers.DB.
+		Where("provider = ? AND provider_user_id = ?", gothUser.Provider, gothUser.UserID).
+		First(&identity).Error
 	if err == nil {
-		// User exists, update OAuth tokens
-		return updateExistingOAuthUser(&user, gothUser)
+		// Identity exists, update OAuth tokens
+		if err := updateIdentityTokens(&identity, gothUser); err != nil {
+			return nil, err
+		}
+		var user models.User
+		if err := initializers.DB.First(&user, "id = ?", identity.UserID).Error; err != nil {
+			return nil, fmt.Errorf("database query error: %v", err)
+		}
+		return &user, nil
 	}
 
 	if err != gorm.ErrRecordNotFound {
 		return nil, fmt.Errorf("database query error: %v", err)
 	}
 
-	// User doesn't exist by OAuth ID, try to find by email
-	err = initializers.DB.Where("email = ?", gothUser.Email).First(&user).Error
-	if err == nil {
-		// User exists with this email, link OAuth account
-		return linkOAuthToExistingUser(&user, gothUser)
+	if gothUser.Email == "" {
+		return nil, fmt.Errorf("%s did not return an email address", gothUser.Provider)
 	}
 
-	if err != gorm.ErrRecordNotFound {
+	// An account with this email must link the provider itself, from
+	// settings, so nobody can take over an account by asserting its email.
+	var count int64
+	if err := initializers.DB.Model(&models.User{}).Where("email = ?", gothUser.Email).Count(&count).Error; err != nil {
 		return nil, fmt.Errorf("database query error: %v", err)
 	}
+	if count > 0 {
+		return nil, ErrAccountExists
+	}
 
 	// Create new user
 	return createNewOAuthUser(gothUser)
 }
 
-func updateExistingOAuthUser(user *models.User, gothUser goth.User) (*models.User, error) {
-	// Update user's OAuth tokens and info
-	updates := map[string]interface{}{
-		"name":       gothUser.Name,
-		"avatar_url": gothUser.AvatarURL,
+// linkExplicitIdentity attaches gothUser to the logged-in user who started
+// the link flow. It fails if the identity already belongs to someone else.
+func linkExplicitIdentity(userID uuid.UUID, gothUser goth.User) error {
+	if !isBuiltinProvider(gothUser.Provider) && !oidcProviders[gothUser.Provider] {
+		return fmt.Errorf("unsupported provider: %s", gothUser.Provider)
 	}
 
-	switch gothUser.Provider {
-	case "google":
-		updates["google_access_token"] = gothUser.AccessToken
-		if gothUser.RefreshToken != "" {
-			updates["google_refresh_token"] = gothUser.RefreshToken
-		}
-		if !gothUser.ExpiresAt.IsZero() {
-			updates["google_token_expires_at"] = gothUser.ExpiresAt
-		}
-	case "github":
-		updates["git_hub_access_token"] = gothUser.AccessToken
-		if !gothUser.ExpiresAt.IsZero() {
-			updates["git_hub_token_expires_at"] = gothUser.ExpiresAt
+	var user models.User
+	if err := initializers.DB.First(&user, "id = ?", userID).Error; err != nil {
+		return fmt.Errorf("user not found")
+	}
+
+	var identity models.UserIdentity
+	err := initializers.DB.
+		Where("provider = ? AND provider_user_id = ?", gothUser.Provider, gothUser.UserID).
+		First(&identity).Error
+	if err == nil {
+		if identity.UserID != user.ID {
+			return fmt.Errorf("this %s account is already linked to another user", gothUser.Provider)
 		}
+		return updateIdentityTokens(&identity, gothUser)
 	}
 
-	if err := initializers.DB.Model(user).Updates(updates).Error; err != nil {
-		return nil, fmt.Errorf("failed to update user: %v", err)
+	if err != gorm.ErrRecordNotFound {
+		return fmt.Errorf("database query error: %v", err)
 	}
 
-	return user, nil
+	return linkIdentity(initializers.DB, &user, gothUser)
 }
 
-func linkOAuthToExistingUser(user *models.User, gothUser goth.User) (*models.User, error) {
-	// Link OAuth account to existing user
-	updates := map[string]interface{}{
-		"name":       gothUser.Name,
-		"avatar_url": gothUser.AvatarURL,
-		"provider":   gothUser.Provider,
+func updateIdentityTokens(identity *models.UserIdentity, gothUser goth.User) error {
+	// Saved through the struct rather than a column map so the encrypted
+	// serializer applies to the token fields.
+	identity.Email = gothUser.Email
+	identity.AccessToken = &gothUser.AccessToken
+	if gothUser.RefreshToken != "" {
+		identity.RefreshToken = &gothUser.RefreshToken
+	}
+	if !gothUser.ExpiresAt.IsZero() {
+		identity.TokenExpiresAt = &gothUser.ExpiresAt
 	}
 
-	switch gothUser.Provider {
-	case "google":
-		updates["google_id"] = gothUser.UserID
-		updates["google_access_token"] = gothUser.AccessToken
-		if gothUser.RefreshToken != "" {
-			updates["google_refresh_token"] = gothUser.RefreshToken
-		}
-		if !gothUser.ExpiresAt.IsZero() {
-			updates["google_token_expires_at"] = gothUser.ExpiresAt
-		}
-	case "github":
-		updates["git_hub_id"] = gothUser.UserID
-		updates["git_hub_access_token"] = gothUser.AccessToken
-		if !gothUser.ExpiresAt.IsZero() {
-			updates["git_hub_token_expires_at"] = gothUser.ExpiresAt
-		}
+	if err := initializers.DB.Save(identity).Error; err != nil {
+		return fmt.Errorf("failed to update identity: %v", err)
+	}
+
+	return nil
+}
+
+func linkIdentity(tx *gorm.DB, user *models.User, gothUser goth.User) error {
+	identity := models.UserIdentity{
+		UserID:         user.ID,
+		Provider:       gothUser.Provider,
+		ProviderUserID: gothUser.UserID,
+		Email:          gothUser.Email,
+		AccessToken:    &gothUser.AccessToken,
+	}
+	if gothUser.RefreshToken != "" {
+		identity.RefreshToken = &gothUser.RefreshToken
+	}
+	if !gothUser.ExpiresAt.IsZero() {
+		identity.TokenExpiresAt = &gothUser.ExpiresAt
 	}
 
-	if err := initializers.DB.Model(user).Updates(updates).Error; err != nil {
-		return nil, fmt.Errorf("failed to link OAuth account: %v", err)
+	if err := tx.Create(&identity).Error; err != nil {
+		return fmt.Errorf("failed to link OAuth account: %v", err)
 	}
 
-	return user, nil
+	return nil
 }
 
 func createNewOAuthUser(gothUser goth.User) (*models.User, error) {
 	user := models.User{
-		ID:        uuid.New(),
-		Email:     gothUser.Email,
-		Provider:  &gothUser.Provider,
+		ID:       uuid.New(),
+		Email:    gothUser.Email,
+		Provider: &gothUser.Provider,
 	}
-
-	switch gothUser.Provider {
-	case "google":
-		user.GoogleID = &gothUser.UserID
-		user.GoogleAccessToken = &gothUser.AccessToken
-		if gothUser.RefreshToken != "" {
-			user.GoogleRefreshToken = &gothUser.RefreshToken
-		}
-		if !gothUser.ExpiresAt.IsZero() {
-			user.GoogleTokenExpiresAt = &gothUser.ExpiresAt
-		}
-	case "github":
-		user.GitHubID = &gothUser.UserID
-		user.GitHubAccessToken = &gothUser.AccessToken
-		if !gothUser.ExpiresAt.IsZero() {
-			user.GitHubTokenExpiresAt = &gothUser.ExpiresAt
-		}
+	// Only trust the address when the provider says it has confirmed it.
+	if emailVerifiedByProvider(gothUser) {
+		now := time.Now()
+		user.EmailVerified = true
+		user.EmailVerifiedAt = &now
 	}
 
-	if err := initializers.DB.Create(&user).Error; err != nil {
-		return nil, fmt.Errorf("failed to create user: %v", err)
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return fmt.Errorf("failed to create user: %v", err)
+		}
+		return linkIdentity(tx, &user, gothUser)
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return &user, nil
@@ -0,0 +1,160 @@
+package Oauth
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/github"
+	"github.com/markbates/goth/providers/google"
+	"github.com/markbates/goth/providers/openidConnect"
+)
+
+// ProviderConfig describes one login provider. Every value is read from
+// environment variables prefixed with the upper-cased provider name, e.g.
+// GITHUB_CLIENT_ID or KEYCLOAK_DISCOVERY_URL.
+type ProviderConfig struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// DiscoveryURL is only used by generic OpenID Connect providers.
+	DiscoveryURL string
+}
+
+var defaultScopes = map[string][]string{
+	"google": {"email", "profile"},
+	"github": {"read:user", "user:email"},
+	"oidc":   {"openid", "email", "profile"},
+}
+
+// oidcProviders records which registered providers are generic OpenID
+// Connect providers rather than one of the built-in integrations.
+var oidcProviders = map[string]bool{}
+
+func isBuiltinProvider(name string) bool {
+	return name == "google" || name == "github"
+}
+
+// loadProviderConfigs reads OAUTH_PROVIDERS (comma separated). When it is
+// unset, google and github are enabled if their client IDs are present.
+func loadProviderConfigs() []ProviderConfig {
+	names := splitList(os.Getenv("OAUTH_PROVIDERS"))
+	if len(names) == 0 {
+		for _, name := range []string{"google", "github"} {
+			if os.Getenv(envKey(name, "CLIENT_ID")) != "" {
+				names = append(names, name)
+			}
+		}
+	}
+
+	var configs []ProviderConfig
+	for _, name := range names {
+		name = strings.ToLower(name)
+
+		scopes := splitList(os.Getenv(envKey(name, "SCOPES")))
+		if len(scopes) == 0 {
+			if isBuiltinProvider(name) {
+				scopes = defaultScopes[name]
+			} else {
+				scopes = defaultScopes["oidc"]
+			}
+		}
+
+		configs = append(configs, ProviderConfig{
+			Name:         name,
+			ClientID:     os.Getenv(envKey(name, "CLIENT_ID")),
+			ClientSecret: os.Getenv(envKey(name, "CLIENT_SECRET")),
+			RedirectURL:  os.Getenv(envKey(name, "REDIRECT_URL")),
+			Scopes:       scopes,
+			DiscoveryURL: os.Getenv(envKey(name, "DISCOVERY_URL")),
+		})
+	}
+	return configs
+}
+
+func newProvider(cfg ProviderConfig) (goth.Provider, error) {
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return nil, fmt.Errorf("client ID and secret are required")
+	}
+
+	switch cfg.Name {
+	case "google":
+		provider := google.New(cfg.ClientID, cfg.ClientSecret, cfg.RedirectURL, cfg.Scopes...)
+		// Force refresh token by adding extra auth parameters
+		provider.SetAccessType("offline") // Ensures refresh token is received
+		provider.SetPrompt("consent")
+		return provider, nil
+	case "github":
+		return github.New(cfg.ClientID, cfg.ClientSecret, cfg.RedirectURL, cfg.Scopes...), nil
+	default:
+		if cfg.DiscoveryURL == "" {
+			return nil, fmt.Errorf("%s is required for OpenID Connect providers", envKey(cfg.Name, "DISCOVERY_URL"))
+		}
+		provider, err := openidConnect.NewNamed(cfg.Name, cfg.ClientID, cfg.ClientSecret, cfg.RedirectURL, cfg.DiscoveryURL, cfg.Scopes...)
+		if err != nil {
+			return nil, fmt.Errorf("OpenID Connect discovery failed: %v", err)
+		}
+		return provider, nil
+	}
+}
+
+// registerProviders builds and registers every configured provider. A
+// misconfigured provider is logged and skipped so the others keep working.
+func registerProviders() {
+	var providers []goth.Provider
+	for _, cfg := range loadProviderConfigs() {
+		provider, err := newProvider(cfg)
+		if err != nil {
+			log.Printf("⚠️  Skipping OAuth provider %s: %v", cfg.Name, err)
+			continue
+		}
+		if !isBuiltinProvider(cfg.Name) {
+			oidcProviders[cfg.Name] = true
+		}
+		providers = append(providers, provider)
+		log.Printf("OAuth provider %s registered with scopes %v", cfg.Name, cfg.Scopes)
+	}
+
+	goth.UseProviders(providers...)
+}
+
+// emailVerifiedByProvider reports whether the provider vouches for the
+// user's email address. GitHub only returns verified addresses; Google and
+// OpenID Connect providers say so in the userinfo claims.
+func emailVerifiedByProvider(gothUser goth.User) bool {
+	if gothUser.Provider == "github" {
+		return true
+	}
+	for _, claim := range []string{"email_verified", "verified_email"} {
+		switch v := gothUser.RawData[claim].(type) {
+		case bool:
+			if v {
+				return true
+			}
+		case string:
+			// Some providers send the claim as a string.
+			if strings.EqualFold(v, "true") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func envKey(provider, key string) string {
+	return strings.ToUpper(provider) + "_" + key
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+)
+
+// ErrSuspended is returned for accounts an administrator has suspended.
+var ErrSuspended = fmt.Errorf("account suspended")
+
+// LoadActiveUser loads the user and rejects suspended accounts.
+func LoadActiveUser(userID uuid.UUID) (*models.User, error) {
+	var user models.User
+	if err := initializers.DB.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	if user.IsSuspended() {
+		return nil, ErrSuspended
+	}
+	return &user, nil
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/mailer"
+	"github.com/basit/fileshare-backend/models"
+)
+
+const (
+	// freeAttempts failures are allowed before the first lockout.
+	freeAttempts = 5
+	// The first lockout lasts baseLockout and doubles with each further
+	// failure, up to maxLockout.
+	baseLockout = 30 * time.Second
+	maxLockout  = time.Hour
+	// Counters reset once no failure has been seen for failureWindow.
+	failureWindow = 24 * time.Hour
+)
+
+// ErrLockedOut is returned while a key is locked.
+type ErrLockedOut struct {
+	RetryAfter time.Duration
+}
+
+func (e *ErrLockedOut) Error() string {
+	return fmt.Sprintf("too many failed attempts, try again in %s", e.RetryAfter.Round(time.Second))
+}
+
+// NormalizeEmail is the form emails are stored and looked up in.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func LoginThrottleKey(email string) string {
+	return "login:" + NormalizeEmail(email)
+}
+
+func FileThrottleKey(slug string) string {
+	return "file:" + slug
+}
+
+// CheckLockout returns an *ErrLockedOut if key is currently locked.
+func CheckLockout(key string) error {
+	var throttle models.AuthThrottle
+	err := initializers.DB.
+		Where("key = ? AND locked_until > ?", key, time.Now()).
+		Limit(1).
+		Find(&throttle).Error
+	if err != nil {
+		return fmt.Errorf("failed to check lockout: %v", err)
+	}
+	if throttle.LockedUntil != nil {
+		return &ErrLockedOut{RetryAfter: time.Until(*throttle.LockedUntil)}
+	}
+	return nil
+}
+
+// RegisterFailure records a failed attempt against key. If the failure
+// starts a lockout, the lockout duration and failure count are returned.
+func RegisterFailure(key string) (time.Duration, int, error) {
+	now := time.Now()
+
+	var throttle models.AuthThrottle
+	err := initializers.DB.Raw(`
+		INSERT INTO auth_throttles (key, failures, last_failure_at)
+		VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN auth_throttles.last_failure_at < ? THEN 1 ELSE auth_throttles.failures + 1 END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING *`,
+		key, now, now.Add(-failureWindow),
+	).Scan(&throttle).Error
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to record attempt: %v", err)
+	}
+
+	if throttle.Failures <= freeAttempts {
+		return 0, throttle.Failures, nil
+	}
+
+	lockout := lockoutDuration(throttle.Failures)
+	if err := initializers.DB.Model(&models.AuthThrottle{}).
+		Where("key = ?", key).
+		Update("locked_until", now.Add(lockout)).Error; err != nil {
+		return 0, throttle.Failures, fmt.Errorf("failed to lock: %v", err)
+	}
+
+	return lockout, throttle.Failures, nil
+}
+
+// PruneThrottles deletes counters that have gone a failure window without a
+// failure and are no longer locked. Failed logins for emails that don't
+// exist leave counters that would otherwise never be removed. It returns the
+// number deleted.
+func PruneThrottles(ctx context.Context) (int, error) {
+	now := time.Now()
+	result := initializers.DB.WithContext(ctx).
+		Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)", now.Add(-failureWindow), now).
+		Delete(&models.AuthThrottle{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("error pruning auth throttles: %v", result.Error)
+	}
+	return int(result.RowsAffected), nil
+}
+
+// ResetFailures clears the counter after a successful attempt.
+func ResetFailures(key string) error {
+	return initializers.DB.Where("key = ?", key).Delete(&models.AuthThrottle{}).Error
+}
+
+func lockoutDuration(failures int) time.Duration {
+	exp := failures - freeAttempts - 1
+	if exp > 16 {
+		return maxLockout
+	}
+	d := time.Duration(float64(baseLockout) * math.Pow(2, float64(exp)))
+	if d > maxLockout {
+		return maxLockout
+	}
+	return d
+}
+
+// lockoutEmailTimeout bounds sending a lockout email, which happens after
+// the request that caused the lockout has been answered.
+const lockoutEmailTimeout = 30 * time.Second
+
+// RecordLockout stores a lockout event for the owner. The owner is emailed
+// about the first lockout of a run of failures only; the later ones are
+// listed with their security events. The email is sent in the background,
+// so the failed attempt isn't slowed down by the mail server, and its
+// failures are only logged.
+func RecordLockout(ctx context.Context, event models.LockoutEvent) error {
+	if err := initializers.DB.WithContext(ctx).Create(&event).Error; err != nil {
+		return fmt.Errorf("failed to record lockout: %v", err)
+	}
+	if event.Failures > freeAttempts+1 {
+		return nil
+	}
+
+	go sendLockoutEmail(event)
+	return nil
+}
+
+func sendLockoutEmail(event models.LockoutEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), lockoutEmailTimeout)
+	defer cancel()
+
+	var owner models.User
+	if err := initializers.DB.WithContext(ctx).First(&owner, "id = ?", event.UserID).Error; err != nil {
+		return
+	}
+
+	subject := "your account"
+	if event.FileID != nil {
+		var file models.File
+		if err := initializers.DB.WithContext(ctx).Select("original_name").First(&file, "id = ?", event.FileID).Error; err == nil {
+			subject = fmt.Sprintf("your file %q", file.OriginalName)
+		}
+	}
+
+	msg := mailer.LockoutEmail(owner.Email, subject, event.IPAddress, event.Failures, event.LockedUntil)
+	if err := initializers.Mailer.Send(ctx, msg); err != nil {
+		log.Printf("Lockout email failed for user %s: %v", owner.ID, err)
+	}
+}
+
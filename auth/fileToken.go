@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	extendExpiryTokenType = "extend_expiry"
+	dataExportTokenType   = "data_export"
+)
+
+// IssueExtendExpiryToken signs a link token that extends fileID once. It is
+// bound to the file's current expiry, so it stops working after it has been
+// used or the expiry has been changed some other way.
+func IssueExtendExpiryToken(fileID uuid.UUID, expiresAt time.Time) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": fileID.String(),
+		"typ": extendExpiryTokenType,
+		"cur": expiresAt.Unix(),
+		"exp": expiresAt.Unix(),
+	})
+
+	signed, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign extend token: %v", err)
+	}
+	return signed, nil
+}
+
+// ParseExtendExpiryToken returns the file and the expiry the token was issued
+// against.
+func ParseExtendExpiryToken(tokenStr string) (uuid.UUID, time.Time, error) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(os.Getenv("JWT_SECRET")), nil
+	})
+	if err != nil || !token.Valid {
+		return uuid.Nil, time.Time{}, fmt.Errorf("invalid or expired link")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != extendExpiryTokenType {
+		return uuid.Nil, time.Time{}, fmt.Errorf("invalid or expired link")
+	}
+
+	sub, _ := claims["sub"].(string)
+	fileID, err := uuid.Parse(sub)
+	if err != nil {
+		return uuid.Nil, time.Time{}, fmt.Errorf("invalid or expired link")
+	}
+	cur, ok := claims["cur"].(float64)
+	if !ok {
+		return uuid.Nil, time.Time{}, fmt.Errorf("invalid or expired link")
+	}
+
+	return fileID, time.Unix(int64(cur), 0), nil
+}
+
+// IssueDataExportToken signs a download link for a data export archive. It
+// expires with the archive.
+func IssueDataExportToken(fileID uuid.UUID, expiresAt time.Time) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": fileID.String(),
+		"typ": dataExportTokenType,
+		"exp": expiresAt.Unix(),
+	})
+
+	signed, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign data export token: %v", err)
+	}
+	return signed, nil
+}
+
+// ParseDataExportToken returns the archive a download link points to.
+func ParseDataExportToken(tokenStr string) (uuid.UUID, error) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(os.Getenv("JWT_SECRET")), nil
+	})
+	if err != nil || !token.Valid {
+		return uuid.Nil, fmt.Errorf("invalid or expired link")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != dataExportTokenType {
+		return uuid.Nil, fmt.Errorf("invalid or expired link")
+	}
+
+	sub, _ := claims["sub"].(string)
+	fileID, err := uuid.Parse(sub)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid or expired link")
+	}
+	return fileID, nil
+}
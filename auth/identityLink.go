@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// IdentityLinkCookie carries the link token from startIdentityLink to the
+// OAuth callback. It is only ever set on the response to that authenticated
+// mutation, so a link token cannot be planted in someone else's browser.
+const IdentityLinkCookie = "oauth_link_token"
+
+// IdentityLinkStatePrefix marks OAuth state values created for the link flow.
+const IdentityLinkStatePrefix = "link:"
+
+// SetIdentityLinkCookie stores token for the OAuth callback. SameSite=None
+// because the frontend calls the API cross-origin.
+func SetIdentityLinkCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     IdentityLinkCookie,
+		Value:    token,
+		HttpOnly: true,
+		Secure:   true,
+		Path:     "/auth",
+		SameSite: http.SameSiteNoneMode,
+		MaxAge:   int(IdentityLinkTokenTTL.Seconds()),
+	})
+}
+
+// ClearIdentityLinkCookie removes the link token cookie.
+func ClearIdentityLinkCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     IdentityLinkCookie,
+		Value:    "",
+		HttpOnly: true,
+		Secure:   true,
+		Path:     "/auth",
+		SameSite: http.SameSiteNoneMode,
+		MaxAge:   -1,
+	})
+}
+
+// IsIdentityLinkState reports whether an OAuth state value was issued for the
+// link flow.
+func IsIdentityLinkState(state string) bool {
+	return strings.HasPrefix(state, IdentityLinkStatePrefix)
+}
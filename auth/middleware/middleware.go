@@ -53,7 +53,11 @@ func AuthOptional() gin.HandlerFunc {
 				userID, err := auth.ValidateToken(token)
 				if err == nil {
 					if parsedUID, err := uuid.Parse(userID); err == nil {
-						c.Set("userID", parsedUID)
+						// Suspended accounts continue as anonymous
+						if user, err := auth.LoadActiveUser(parsedUID); err == nil {
+							c.Set("userID", parsedUID)
+							c.Set("userRole", user.Role)
+						}
 					}
 				}
 			}
@@ -89,11 +93,37 @@ func AuthRequired() gin.HandlerFunc {
 			return
 		}
 
+		user, err := auth.LoadActiveUser(parsedUID)
+		if err == auth.ErrSuspended {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Account suspended"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
 		c.Set("userID", parsedUID)
+		c.Set("userRole", user.Role)
 		c.Next()
 	}
 }
 
+// RequireRole only lets through users whose role is one of roles. It must
+// run after AuthRequired.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("userRole")
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	}
+}
+
 const GinContextKey = "GinContextKey"
 
 func GinContextToContextMiddleware() gin.HandlerFunc {
@@ -102,6 +132,9 @@ func GinContextToContextMiddleware() gin.HandlerFunc {
 		if uid, exists := c.Get("userID"); exists {
 			ctx = context.WithValue(ctx, resolvers.UserIDKey, uid)
 		}
+		if role, exists := c.Get("userRole"); exists {
+			ctx = context.WithValue(ctx, resolvers.UserRoleKey, role)
+		}
 		c.Request = c.Request.WithContext(ctx)
 		c.Next()
 	}
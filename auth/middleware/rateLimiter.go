@@ -1,62 +1,34 @@
 package middleware
 
 import (
+	"log"
 	"net/http"
-	"sync"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/time/rate"
-)
-
-type clientLimiter struct {
-	limiter  *rate.Limiter
-	lastSeen time.Time
-}
+	"github.com/google/uuid"
 
-var (
-	clients   = make(map[string]*clientLimiter)
-	mu        sync.Mutex
-	rateLimit = rate.Every(1 * time.Second) // 1 req/sec
-	burst     = 5
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/ratelimit"
 )
 
-func cleanupClients() {
-	for {
-		time.Sleep(time.Minute)
-		mu.Lock()
-		for ip, c := range clients {
-			if time.Since(c.lastSeen) > 3*time.Minute {
-				delete(clients, ip)
-			}
-		}
-		mu.Unlock()
-	}
-}
-
-func getLimiter(ip string) *rate.Limiter {
-	mu.Lock()
-	defer mu.Unlock()
-
-	cl, exists := clients[ip]
-	if !exists {
-		limiter := rate.NewLimiter(rateLimit, burst)
-		clients[ip] = &clientLimiter{limiter, time.Now()}
-		return limiter
-	}
-
-	cl.lastSeen = time.Now()
-	return cl.limiter
-}
-
-func RateLimitMiddleware() gin.HandlerFunc {
-	go cleanupClients()
+// RateLimit applies the named policy. Place it after the auth middleware so
+// authenticated callers get their own per-user budget.
+func RateLimit(policyName string) gin.HandlerFunc {
+	policy := ratelimit.PolicyFor(policyName)
 
 	return func(c *gin.Context) {
-		ip := c.ClientIP()
-		limiter := getLimiter(ip)
+		identity, authenticated := rateLimitIdentity(c)
 
-		if !limiter.Allow() {
+		res, err := initializers.RateLimiter.Allow(c.Request.Context(), policy.Name+":"+identity, policy.LimitFor(authenticated))
+		if err != nil {
+			// Fail open so a limiter outage doesn't take the API down
+			log.Printf("Rate limiter error: %v", err)
+			c.Next()
+			return
+		}
+
+		ratelimit.WriteHeaders(c.Writer.Header(), res)
+		if !res.Allowed {
 			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
 				"error": "Too many requests",
 			})
@@ -66,3 +38,12 @@ func RateLimitMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// rateLimitIdentity keys authenticated requests by user and everything else
+// by client IP.
+func rateLimitIdentity(c *gin.Context) (string, bool) {
+	if uid, ok := c.Get("userID"); ok {
+		return "user:" + uid.(uuid.UUID).String(), true
+	}
+	return "ip:" + c.ClientIP(), false
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/google/uuid"
+
+	"github.com/basit/fileshare-backend/auth"
+	"github.com/basit/fileshare-backend/graph/resolvers"
+)
+
+// WebsocketInit authenticates a GraphQL websocket from its connection_init
+// payload, since browsers can't set headers on the upgrade request. Clients
+// send {"Authorization": "Bearer <access token>"}. Connections without a
+// token are anonymous; a bad token is rejected. An authenticated connection
+// is closed when its token expires, and the client reconnects with a fresh
+// one, or as soon as the user is suspended or deleted.
+func WebsocketInit(ctx context.Context, payload transport.InitPayload) (context.Context, *transport.InitPayload, error) {
+	header := payload.Authorization()
+	if header == "" {
+		return ctx, &payload, nil
+	}
+
+	parts := strings.Split(header, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, nil, fmt.Errorf("invalid authorization header")
+	}
+
+	userID, expiresAt, err := auth.ValidateTokenExpiry(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid token")
+	}
+	parsedUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid user ID")
+	}
+	user, err := auth.LoadActiveUser(parsedUID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx = context.WithValue(ctx, resolvers.UserIDKey, parsedUID)
+	ctx = context.WithValue(ctx, resolvers.UserRoleKey, user.Role)
+	// gqlgen closes the connection once this context ends.
+	ctx, cancel := context.WithDeadline(ctx, expiresAt)
+	go watchWebsocketUser(ctx, cancel, parsedUID)
+	return ctx, &payload, nil
+}
+
+// websocketUserCheckInterval is how often an open websocket's user is
+// checked again.
+const websocketUserCheckInterval = time.Minute
+
+// watchWebsocketUser ends ctx if the user stops being active, and releases
+// it once the connection ends.
+func watchWebsocketUser(ctx context.Context, cancel context.CancelFunc, userID uuid.UUID) {
+	defer cancel()
+
+	ticker := time.NewTicker(websocketUserCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := auth.LoadActiveUser(userID); err != nil {
+				return
+			}
+		}
+	}
+}
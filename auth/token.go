@@ -6,16 +6,21 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
 )
 
 func GenerateTokens(userID string) (accessToken string, refreshToken string, err error) {
 	jwtSecret := []byte(os.Getenv("JWT_SECRET"))
+	now := time.Now()
 
 	// Access Token (15 min - 1 hr)
 	access := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"sub": userID,
-		"exp": time.Now().Add(time.Minute * 15).Unix(), // SHORT lifespan
+		"exp": now.Add(time.Minute * 15).Unix(), // SHORT lifespan
 		"typ": "access",
+		"iat": now.Unix(),
 	})
 
 	accessToken, err = access.SignedString(jwtSecret)
@@ -26,8 +31,9 @@ func GenerateTokens(userID string) (accessToken string, refreshToken string, err
 	// Refresh Token (7 - 30 days)
 	refresh := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"sub": userID,
-		"exp": time.Now().Add(time.Hour * 24 * 30).Unix(), // LONG lifespan
+		"exp": now.Add(time.Hour * 24 * 30).Unix(), // LONG lifespan
 		"typ": "refresh",
+		"iat": now.Unix(),
 	})
 
 	refreshToken, err = refresh.SignedString(jwtSecret)
@@ -39,6 +45,24 @@ func GenerateTokens(userID string) (accessToken string, refreshToken string, err
 }
 
 func ValidateToken(tokenStr string) (string, error) {
+	userID, _, err := ValidateTokenExpiry(tokenStr)
+	return userID, err
+}
+
+// ValidateTokenExpiry is ValidateToken that also returns when the token
+// expires, for connections that outlive a single request. Only access
+// tokens are accepted.
+func ValidateTokenExpiry(tokenStr string) (string, time.Time, error) {
+	return validateSessionToken(tokenStr, "access")
+}
+
+// ValidateRefreshToken checks a refresh token and returns its user ID.
+func ValidateRefreshToken(tokenStr string) (string, error) {
+	userID, _, err := validateSessionToken(tokenStr, "refresh")
+	return userID, err
+}
+
+func validateSessionToken(tokenStr string, typ string) (string, time.Time, error) {
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -47,16 +71,56 @@ func ValidateToken(tokenStr string) (string, error) {
 	})
 
 	if err != nil {
-		return "", fmt.Errorf("failed to parse token: %v", err)
+		return "", time.Time{}, fmt.Errorf("failed to parse token: %v", err)
 	}
 
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+		// A refresh token must not work as a bearer token, nor must links
+		// signed with the same secret, such as expiry extension or data
+		// export links.
+		if claims["typ"] != typ {
+			return "", time.Time{}, fmt.Errorf("invalid token type")
+		}
 		userID, ok := claims["sub"].(string)
 		if !ok {
-			return "", fmt.Errorf("invalid sub claim")
+			return "", time.Time{}, fmt.Errorf("invalid sub claim")
+		}
+		exp, err := claims.GetExpirationTime()
+		if err != nil || exp == nil {
+			return "", time.Time{}, fmt.Errorf("invalid exp claim")
 		}
-		return userID, nil
+		if err := checkIssuedAfterPasswordChange(userID, claims); err != nil {
+			return "", time.Time{}, err
+		}
+		return userID, exp.Time, nil
+	}
+
+	return "", time.Time{}, fmt.Errorf("invalid token")
+}
+
+// checkIssuedAfterPasswordChange rejects tokens issued before the user's
+// password was last reset. Tokens without an iat claim predate it being
+// added, so they count as issued before any reset.
+func checkIssuedAfterPasswordChange(userID string, claims jwt.MapClaims) error {
+	var changedAt *time.Time
+	if err := initializers.DB.Model(&models.User{}).
+		Where("id = ?", userID).
+		Select("password_changed_at").
+		Scan(&changedAt).Error; err != nil {
+		return fmt.Errorf("failed to check token: %v", err)
+	}
+	if changedAt == nil {
+		return nil
 	}
 
-	return "", fmt.Errorf("invalid token")
+	var issuedAt time.Time
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		issuedAt = iat.Time
+	}
+	// iat has whole seconds, so a token issued in the second of the reset
+	// still passes.
+	if issuedAt.Before(changedAt.Truncate(time.Second)) {
+		return fmt.Errorf("token revoked")
+	}
+	return nil
 }
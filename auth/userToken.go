@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm/clause"
+
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+)
+
+const (
+	EmailVerificationTokenTTL = 24 * time.Hour
+	PasswordResetTokenTTL     = time.Hour
+	IdentityLinkTokenTTL      = 10 * time.Minute
+)
+
+// IssueUserToken creates a new single-use token for purpose and returns the
+// raw value to be sent to the user. Any earlier unused tokens with the same
+// purpose are invalidated.
+func IssueUserToken(userID uuid.UUID, purpose string, ttl time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	raw := base64.RawURLEncoding.EncodeToString(buf)
+
+	now := time.Now()
+	if err := initializers.DB.Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", now).Error; err != nil {
+		return "", fmt.Errorf("failed to invalidate previous tokens: %v", err)
+	}
+
+	token := models.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashUserToken(raw),
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := initializers.DB.Create(&token).Error; err != nil {
+		return "", fmt.Errorf("failed to store token: %v", err)
+	}
+
+	return raw, nil
+}
+
+// ConsumeUserToken marks the token as used and returns it. The update is a
+// single conditional statement so a token can't be redeemed twice, even by
+// concurrent requests.
+func ConsumeUserToken(purpose, raw string) (*models.UserToken, error) {
+	var tokens []models.UserToken
+	result := initializers.DB.Model(&tokens).
+		Clauses(clause.Returning{}).
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hashUserToken(raw), purpose, time.Now()).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to redeem token: %v", result.Error)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("invalid or expired token")
+	}
+	return &tokens[0], nil
+}
+
+func hashUserToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,39 @@
+// Package config reads optional settings from the environment. A value that
+// is missing falls back to the default; one that doesn't parse is logged and
+// ignored.
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Int returns the positive integer in key, or def.
+func Int(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Printf("⚠️  Ignoring %s=%q", key, value)
+		return def
+	}
+	return n
+}
+
+// Duration returns the positive duration in key, such as "15m", or def.
+func Duration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("⚠️  Ignoring %s=%q", key, value)
+		return def
+	}
+	return d
+}
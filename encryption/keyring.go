@@ -0,0 +1,167 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// prefix marks a value produced by Encrypt. Values without it are treated as
+// legacy plaintext so existing rows stay readable until they are migrated.
+const prefix = "enc:v1:"
+
+type keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+var (
+	ring   *keyring
+	ringMu sync.RWMutex
+)
+
+// LoadKeysFromEnv reads TOKEN_ENCRYPTION_KEYS, a comma separated list of
+// "id:base64key" pairs holding 32-byte AES-256 keys. The first key encrypts
+// new values; the rest are kept only to decrypt values written before a
+// rotation.
+func LoadKeysFromEnv() error {
+	return LoadKeys(os.Getenv("TOKEN_ENCRYPTION_KEYS"))
+}
+
+func LoadKeys(spec string) error {
+	kr := &keyring{keys: make(map[string]cipher.AEAD)}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return fmt.Errorf("invalid key entry %q, expected id:base64key", entry)
+		}
+		if _, exists := kr.keys[id]; exists {
+			return fmt.Errorf("duplicate key id %q", id)
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("key %q is not valid base64: %v", id, err)
+		}
+		if len(raw) != 32 {
+			return fmt.Errorf("key %q must be 32 bytes, got %d", id, len(raw))
+		}
+
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return fmt.Errorf("key %q: %v", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return fmt.Errorf("key %q: %v", id, err)
+		}
+
+		if kr.primary == "" {
+			kr.primary = id
+		}
+		kr.keys[id] = aead
+	}
+
+	if kr.primary == "" {
+		return fmt.Errorf("no encryption keys configured")
+	}
+
+	ringMu.Lock()
+	ring = kr
+	ringMu.Unlock()
+	return nil
+}
+
+func currentRing() (*keyring, error) {
+	ringMu.RLock()
+	defer ringMu.RUnlock()
+	if ring == nil {
+		return nil, fmt.Errorf("encryption keys not loaded")
+	}
+	return ring, nil
+}
+
+// PrimaryKeyID returns the id of the key used for new values.
+func PrimaryKeyID() (string, error) {
+	kr, err := currentRing()
+	if err != nil {
+		return "", err
+	}
+	return kr.primary, nil
+}
+
+// Encrypt seals plaintext with the primary key using AES-GCM.
+func Encrypt(plaintext string) (string, error) {
+	kr, err := currentRing()
+	if err != nil {
+		return "", err
+	}
+
+	aead := kr.keys[kr.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(kr.primary))
+	return prefix + kr.primary + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value produced by Encrypt. Legacy plaintext values are
+// returned unchanged.
+func Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	kr, err := currentRing()
+	if err != nil {
+		return "", err
+	}
+
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	if !ok {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	aead, ok := kr.keys[id]
+	if !ok {
+		return "", fmt.Errorf("unknown encryption key %q", id)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value: %v", err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value with key %q: %v", id, err)
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted reports whether value was produced by Encrypt.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// EncryptedPrefix returns the prefix of values sealed with key id, for use in
+// SQL LIKE filters when looking for rows that still need re-encryption.
+func EncryptedPrefix(id string) string {
+	return prefix + id + ":"
+}
@@ -0,0 +1,63 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("encrypted", Serializer{})
+}
+
+// Serializer is a GORM serializer for string and *string fields that stores
+// them encrypted. Use it with `gorm:"serializer:encrypted"`.
+type Serializer struct{}
+
+func (Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	fieldValue := reflect.New(field.FieldType)
+
+	if dbValue != nil {
+		var stored string
+		switch v := dbValue.(type) {
+		case string:
+			stored = v
+		case []byte:
+			stored = string(v)
+		default:
+			return fmt.Errorf("unsupported encrypted column type %T", dbValue)
+		}
+
+		plaintext, err := Decrypt(stored)
+		if err != nil {
+			return err
+		}
+
+		if field.FieldType.Kind() == reflect.Ptr {
+			fieldValue.Elem().Set(reflect.ValueOf(&plaintext))
+		} else {
+			fieldValue.Elem().SetString(plaintext)
+		}
+	}
+
+	field.ReflectValueOf(ctx, dst).Set(fieldValue.Elem())
+	return nil
+}
+
+func (Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	switch v := fieldValue.(type) {
+	case nil:
+		return nil, nil
+	case *string:
+		if v == nil {
+			return nil, nil
+		}
+		return Encrypt(*v)
+	case string:
+		return Encrypt(v)
+	default:
+		return nil, fmt.Errorf("unsupported encrypted field type %T", fieldValue)
+	}
+}
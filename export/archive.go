@@ -0,0 +1,236 @@
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"gorm.io/gorm"
+
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+)
+
+type profile struct {
+	ID             string           `json:"id"`
+	Email          string           `json:"email"`
+	Role           string           `json:"role"`
+	Provider       *string          `json:"provider"`
+	EmailVerified  bool             `json:"emailVerified"`
+	CreatedAt      time.Time        `json:"createdAt"`
+	Preferences    map[string]bool  `json:"preferences"`
+	LinkedAccounts []linkedAccount  `json:"linkedAccounts"`
+	Webhooks       []webhookSummary `json:"webhooks"`
+}
+
+type linkedAccount struct {
+	Provider string    `json:"provider"`
+	Email    string    `json:"email,omitempty"`
+	LinkedAt time.Time `json:"linkedAt"`
+}
+
+type webhookSummary struct {
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type manifest struct {
+	GeneratedAt time.Time      `json:"generatedAt"`
+	UserID      string         `json:"userId"`
+	Files       []manifestFile `json:"files"`
+	Skipped     []manifestFile `json:"skipped"`
+}
+
+type manifestFile struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Path   string `json:"path,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// WriteArchive writes a ZIP of everything held about user to w:
+//
+//	profile.json       account, preferences, linked accounts and webhooks
+//	files.ndjson       metadata of every file
+//	downloads.ndjson   download history of every file
+//	files/<id>/<name>  the files themselves
+//	manifest.json      what was included, and any files that couldn't be
+//
+// Objects are copied from the bucket one at a time, so memory use doesn't
+// grow with the size of the account. Earlier export archives are left out.
+func WriteArchive(ctx context.Context, db *gorm.DB, user *models.User, w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	if err := writeProfile(ctx, db, zw, user); err != nil {
+		return fmt.Errorf("error writing profile: %v", err)
+	}
+
+	entry, err := zw.Create("files.ndjson")
+	if err != nil {
+		return err
+	}
+	if err := Files(ctx, db, user.ID, entry, NDJSON); err != nil {
+		return fmt.Errorf("error writing file metadata: %v", err)
+	}
+
+	entry, err = zw.Create("downloads.ndjson")
+	if err != nil {
+		return err
+	}
+	if err := DownloadEvents(ctx, db, EventFilter{OwnerID: user.ID}, entry, NDJSON); err != nil {
+		return fmt.Errorf("error writing download history: %v", err)
+	}
+
+	m := manifest{
+		GeneratedAt: time.Now().UTC(),
+		UserID:      user.ID.String(),
+		Files:       []manifestFile{},
+		Skipped:     []manifestFile{},
+	}
+	if err := writeObjects(ctx, db, zw, user, &m); err != nil {
+		return err
+	}
+
+	entry, err = zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(entry, m); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func writeProfile(ctx context.Context, db *gorm.DB, zw *zip.Writer, user *models.User) error {
+	p := profile{
+		ID:            user.ID.String(),
+		Email:         user.Email,
+		Role:          user.Role,
+		Provider:      user.Provider,
+		EmailVerified: user.EmailVerified,
+		CreatedAt:     user.CreatedAt,
+		Preferences: map[string]bool{
+			"downloadAlerts":       user.DownloadAlerts,
+			"expiryReminders":      user.ExpiryReminders,
+			"detailedDownloadLogs": user.DetailedDownloadLogs,
+		},
+		LinkedAccounts: []linkedAccount{},
+		Webhooks:       []webhookSummary{},
+	}
+
+	var identities []models.UserIdentity
+	if err := db.WithContext(ctx).Where("user_id = ?", user.ID).Order("created_at").Find(&identities).Error; err != nil {
+		return err
+	}
+	for _, identity := range identities {
+		p.LinkedAccounts = append(p.LinkedAccounts, linkedAccount{
+			Provider: identity.Provider,
+			Email:    identity.Email,
+			LinkedAt: identity.CreatedAt,
+		})
+	}
+
+	var hooks []models.Webhook
+	if err := db.WithContext(ctx).Where("user_id = ?", user.ID).Order("created_at").Find(&hooks).Error; err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		// Signing secrets stay out of the archive.
+		p.Webhooks = append(p.Webhooks, webhookSummary{
+			URL:       hook.URL,
+			Events:    hook.Events,
+			Active:    hook.Active,
+			CreatedAt: hook.CreatedAt,
+		})
+	}
+
+	entry, err := zw.Create("profile.json")
+	if err != nil {
+		return err
+	}
+	return writeJSON(entry, p)
+}
+
+func writeObjects(ctx context.Context, db *gorm.DB, zw *zip.Writer, user *models.User, m *manifest) error {
+	rows, err := db.WithContext(ctx).
+		Model(&models.File{}).
+		Select("id, original_name, storage_path, created_at").
+		Scopes(models.Uploads).
+		Where("user_id = ?", user.ID).
+		Order("created_at, id").
+		Rows()
+	if err != nil {
+		return fmt.Errorf("error listing files: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var file models.File
+		if err := db.ScanRows(rows, &file); err != nil {
+			return err
+		}
+
+		entryPath := path.Join("files", file.ID.String(), archiveName(file.OriginalName))
+		item := manifestFile{ID: file.ID.String(), Name: file.OriginalName}
+
+		out, err := initializers.S3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(initializers.S3Bucket),
+			Key:    aws.String(file.StoragePath),
+		})
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			item.Reason = "stored object not found"
+			m.Skipped = append(m.Skipped, item)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", file.StoragePath, err)
+		}
+
+		// Most uploads are already compressed, so they are stored as is.
+		entry, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     entryPath,
+			Method:   zip.Store,
+			Modified: file.CreatedAt,
+		})
+		if err == nil {
+			_, err = io.Copy(entry, out.Body)
+		}
+		out.Body.Close()
+		if err != nil {
+			return fmt.Errorf("error copying %s: %v", file.StoragePath, err)
+		}
+
+		item.Path = entryPath
+		m.Files = append(m.Files, item)
+	}
+	return rows.Err()
+}
+
+// archiveName makes an uploaded file name safe to use as a ZIP entry name.
+func archiveName(name string) string {
+	name = strings.NewReplacer("/", "_", "\\", "_", "\x00", "").Replace(name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." {
+		return "file"
+	}
+	return name
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
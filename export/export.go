@@ -0,0 +1,276 @@
+// Package export streams a user's files and download history as CSV or
+// newline-delimited JSON, one row at a time.
+package export
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/basit/fileshare-backend/models"
+)
+
+// Format is an export encoding.
+type Format string
+
+const (
+	CSV    Format = "csv"
+	NDJSON Format = "ndjson"
+)
+
+// ParseFormat maps a format query parameter to a Format. Empty means CSV.
+func ParseFormat(value string) (Format, error) {
+	switch Format(strings.ToLower(value)) {
+	case "", CSV:
+		return CSV, nil
+	case NDJSON, "json", "jsonl":
+		return NDJSON, nil
+	}
+	return "", fmt.Errorf("unknown export format %q, expected csv or ndjson", value)
+}
+
+func (f Format) ContentType() string {
+	if f == NDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+func (f Format) Extension() string {
+	if f == NDJSON {
+		return "ndjson"
+	}
+	return "csv"
+}
+
+// flushEvery is how many rows are written between flushes to the client.
+const flushEvery = 500
+
+// FileRecord is one row of a file listing export.
+type FileRecord struct {
+	ID                string     `json:"id"`
+	OriginalName      string     `json:"name"`
+	FileSize          int64      `json:"size"`
+	ContentType       string     `json:"contentType"`
+	DownloadSlug      string     `json:"downloadSlug"`
+	IsPublic          bool       `json:"isPublic"`
+	PasswordProtected bool       `json:"passwordProtected"`
+	DownloadCount     int64      `json:"downloadCount"`
+	LastDownloadedAt  *time.Time `json:"lastDownloadedAt"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	ExpiresAt         *time.Time `json:"expiresAt"`
+}
+
+var fileHeader = []string{
+	"id", "name", "size", "content_type", "download_slug", "is_public",
+	"password_protected", "download_count", "last_downloaded_at", "created_at", "expires_at",
+}
+
+func (r *FileRecord) csvRecord() []string {
+	return []string{
+		r.ID, r.OriginalName, strconv.FormatInt(r.FileSize, 10), r.ContentType, r.DownloadSlug,
+		strconv.FormatBool(r.IsPublic), strconv.FormatBool(r.PasswordProtected),
+		strconv.FormatInt(r.DownloadCount, 10), formatTime(r.LastDownloadedAt),
+		formatTime(&r.CreatedAt), formatTime(r.ExpiresAt),
+	}
+}
+
+// EventRecord is one row of a download history export.
+type EventRecord struct {
+	ID         string    `json:"id"`
+	FileID     string    `json:"fileId"`
+	FileName   string    `json:"fileName"`
+	CreatedAt  time.Time `json:"downloadedAt"`
+	UserID     *string   `json:"userId"`
+	IPAddress  string    `json:"ipAddress"`
+	UserAgent  string    `json:"userAgent"`
+	Referrer   *string   `json:"referrer"`
+	Country    *string   `json:"country"`
+	City       *string   `json:"city"`
+	Browser    *string   `json:"browser"`
+	OS         *string   `json:"os"`
+	Device     *string   `json:"device"`
+	IsBot      bool      `json:"isBot"`
+	Anonymized bool      `json:"anonymized"`
+}
+
+var eventHeader = []string{
+	"id", "file_id", "file_name", "downloaded_at", "user_id", "ip_address", "user_agent",
+	"referrer", "country", "city", "browser", "os", "device", "is_bot", "anonymized",
+}
+
+func (r *EventRecord) csvRecord() []string {
+	return []string{
+		r.ID, r.FileID, r.FileName, formatTime(&r.CreatedAt), deref(r.UserID), r.IPAddress,
+		r.UserAgent, deref(r.Referrer), deref(r.Country), deref(r.City), deref(r.Browser),
+		deref(r.OS), deref(r.Device), strconv.FormatBool(r.IsBot), strconv.FormatBool(r.Anonymized),
+	}
+}
+
+// EventFilter narrows a download history export to one owner's files and,
+// optionally, one file and a time range (From inclusive, To exclusive).
+type EventFilter struct {
+	OwnerID uuid.UUID
+	FileID  *uuid.UUID
+	From    *time.Time
+	To      *time.Time
+}
+
+// Files writes every file owned by ownerID, oldest first.
+func Files(ctx context.Context, db *gorm.DB, ownerID uuid.UUID, w io.Writer, format Format) error {
+	rows, err := db.WithContext(ctx).
+		Table("files").
+		Select(`id::text AS id, original_name, file_size, content_type, download_slug, is_public,
+			password_hash IS NOT NULL AS password_protected, download_count, last_downloaded_at,
+			created_at, expires_at`).
+		Scopes(models.Uploads).
+		Where("user_id = ?", ownerID).
+		Order("created_at, id").
+		Rows()
+	if err != nil {
+		return err
+	}
+	return stream(db, rows, w, format, fileHeader, func() record { return &FileRecord{} })
+}
+
+// DownloadEvents writes the download events matching filter, oldest first.
+func DownloadEvents(ctx context.Context, db *gorm.DB, filter EventFilter, w io.Writer, format Format) error {
+	query := db.WithContext(ctx).
+		Table("download_events AS e").
+		Select(`e.id::text AS id, e.file_id::text AS file_id, f.original_name AS file_name,
+			e.created_at, e.user_id::text AS user_id, e.ip_address, e.user_agent, e.referrer,
+			e.country, e.city, e.browser, e.os, e.device, e.is_bot,
+			e.anonymized_at IS NOT NULL AS anonymized`).
+		Joins("JOIN files f ON f.id = e.file_id").
+		Where("f.user_id = ?", filter.OwnerID)
+	if filter.FileID != nil {
+		query = query.Where("e.file_id = ?", *filter.FileID)
+	}
+	if filter.From != nil {
+		query = query.Where("e.created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("e.created_at < ?", *filter.To)
+	}
+
+	rows, err := query.Order("e.created_at, e.id").Rows()
+	if err != nil {
+		return err
+	}
+	return stream(db, rows, w, format, eventHeader, func() record { return &EventRecord{} })
+}
+
+type record interface {
+	csvRecord() []string
+}
+
+// stream scans rows one at a time and writes each as soon as it's read.
+func stream(db *gorm.DB, rows *sql.Rows, w io.Writer, format Format, header []string, newRecord func() record) error {
+	defer rows.Close()
+
+	enc := newEncoder(w, format)
+	if err := enc.header(header); err != nil {
+		return err
+	}
+
+	n := 0
+	for rows.Next() {
+		rec := newRecord()
+		if err := db.ScanRows(rows, rec); err != nil {
+			return err
+		}
+		if err := enc.encode(rec); err != nil {
+			return err
+		}
+		if n++; n%flushEvery == 0 {
+			if err := enc.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return enc.flush()
+}
+
+type encoder struct {
+	format Format
+	w      io.Writer
+	csv    *csv.Writer
+	json   *json.Encoder
+}
+
+func newEncoder(w io.Writer, format Format) *encoder {
+	enc := &encoder{format: format, w: w}
+	if format == NDJSON {
+		enc.json = json.NewEncoder(w)
+	} else {
+		enc.csv = csv.NewWriter(w)
+	}
+	return enc
+}
+
+func (e *encoder) header(columns []string) error {
+	if e.csv == nil {
+		return nil
+	}
+	return e.csv.Write(columns)
+}
+
+func (e *encoder) encode(rec record) error {
+	if e.json != nil {
+		return e.json.Encode(rec)
+	}
+	cells := rec.csvRecord()
+	for i, cell := range cells {
+		cells[i] = escapeFormula(cell)
+	}
+	return e.csv.Write(cells)
+}
+
+func (e *encoder) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if f, ok := e.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// escapeFormula stops spreadsheets from evaluating cells that hold
+// user-controlled text such as file names and user agents.
+func escapeFormula(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
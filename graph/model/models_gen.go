@@ -2,28 +2,417 @@
 
 package model
 
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+type AdminFile struct {
+	ID            string  `json:"id"`
+	OriginalName  string  `json:"originalName"`
+	OwnerID       *string `json:"ownerId,omitempty"`
+	OwnerEmail    *string `json:"ownerEmail,omitempty"`
+	FileSize      int32   `json:"fileSize"`
+	DownloadCount int32   `json:"downloadCount"`
+	IsPublic      bool    `json:"isPublic"`
+	CreatedAt     string  `json:"createdAt"`
+	ExpiresAt     *string `json:"expiresAt,omitempty"`
+	// Failed attempts by the expiry cleanup to delete the stored object.
+	CleanupFailures  int32   `json:"cleanupFailures"`
+	CleanupLastError *string `json:"cleanupLastError,omitempty"`
+	// ok, mismatch or missing; null until the file has been checked.
+	IntegrityStatus    *string `json:"integrityStatus,omitempty"`
+	IntegrityCheckedAt *string `json:"integrityCheckedAt,omitempty"`
+	IntegrityDetail    *string `json:"integrityDetail,omitempty"`
+}
+
+type AdminJob struct {
+	Name     string  `json:"name"`
+	Interval string  `json:"interval"`
+	LastRun  *JobRun `json:"lastRun,omitempty"`
+}
+
+type AdminUser struct {
+	ID            string  `json:"id"`
+	Email         string  `json:"email"`
+	Role          Role    `json:"role"`
+	EmailVerified bool    `json:"emailVerified"`
+	Suspended     bool    `json:"suspended"`
+	SuspendedAt   *string `json:"suspendedAt,omitempty"`
+	CreatedAt     string  `json:"createdAt"`
+	FileCount     int32   `json:"fileCount"`
+	StorageUsed   string  `json:"storageUsed"`
+}
+
+type AnalyticsBucket struct {
+	// Start of the bucket (UTC).
+	Start             string `json:"start"`
+	Downloads         int32  `json:"downloads"`
+	UniqueDownloaders int32  `json:"uniqueDownloaders"`
+}
+
+type AnalyticsCount struct {
+	Value string `json:"value"`
+	Count int32  `json:"count"`
+}
+
+type AuditEvent struct {
+	ID         string  `json:"id"`
+	ActorID    *string `json:"actorId,omitempty"`
+	ActorEmail *string `json:"actorEmail,omitempty"`
+	Action     string  `json:"action"`
+	TargetType *string `json:"targetType,omitempty"`
+	TargetID   *string `json:"targetId,omitempty"`
+	IPAddress  *string `json:"ipAddress,omitempty"`
+	UserAgent  *string `json:"userAgent,omitempty"`
+	Metadata   string  `json:"metadata"`
+	CreatedAt  string  `json:"createdAt"`
+}
+
+type AuditEventFilter struct {
+	ActorID    *string `json:"actorId,omitempty"`
+	Action     *string `json:"action,omitempty"`
+	TargetType *string `json:"targetType,omitempty"`
+	TargetID   *string `json:"targetId,omitempty"`
+	From       *string `json:"from,omitempty"`
+	To         *string `json:"to,omitempty"`
+}
+
 type AuthPayload struct {
 	AccessToken  string `json:"accessToken"`
 	RefreshToken string `json:"refreshToken"`
 	User         *User  `json:"user"`
 }
 
+type DataExport struct {
+	ID string `json:"id"`
+	// pending, running, ready, expired or failed.
+	Status      string  `json:"status"`
+	CreatedAt   string  `json:"createdAt"`
+	CompletedAt *string `json:"completedAt,omitempty"`
+	ExpiresAt   *string `json:"expiresAt,omitempty"`
+	// Download link for a ready export. It stops working when the export expires.
+	DownloadURL *string `json:"downloadUrl,omitempty"`
+	Error       *string `json:"error,omitempty"`
+}
+
+// Counters of the buffered download event writer on the instance that answered.
+type EventRecorderStats struct {
+	Queued        int32 `json:"queued"`
+	Capacity      int32 `json:"capacity"`
+	Recorded      int32 `json:"recorded"`
+	Dropped       int32 `json:"dropped"`
+	FailedBatches int32 `json:"failedBatches"`
+}
+
+type FileAnalytics struct {
+	FileID         string               `json:"fileId"`
+	From           string               `json:"from"`
+	To             string               `json:"to"`
+	Granularity    AnalyticsGranularity `json:"granularity"`
+	TotalDownloads int32                `json:"totalDownloads"`
+	// Distinct signed-in users plus distinct client IPs of anonymous downloads,
+	// as stored under DOWNLOAD_IP_MODE. Days whose download events have been
+	// anonymised only keep a daily count, so on those days a downloader is
+	// counted once per day rather than once per range.
+	UniqueDownloaders int32              `json:"uniqueDownloaders"`
+	Series            []*AnalyticsBucket `json:"series"`
+	TopUserAgents     []*AnalyticsCount  `json:"topUserAgents"`
+	Browsers          []*AnalyticsCount  `json:"browsers"`
+	OperatingSystems  []*AnalyticsCount  `json:"operatingSystems"`
+	Referrers         []*AnalyticsCount  `json:"referrers"`
+	// ISO 3166 country codes, from the GeoIP database if one is configured.
+	Countries []*AnalyticsCount `json:"countries"`
+	Cities    []*AnalyticsCount `json:"cities"`
+	// desktop, mobile, tablet, bot or unknown.
+	Devices []*AnalyticsCount `json:"devices"`
+	// Downloads by crawlers and other automated clients.
+	BotDownloads int32 `json:"botDownloads"`
+}
+
+type JobRun struct {
+	ID             string  `json:"id"`
+	JobName        string  `json:"jobName"`
+	Trigger        string  `json:"trigger"`
+	Instance       *string `json:"instance,omitempty"`
+	Status         string  `json:"status"`
+	StartedAt      string  `json:"startedAt"`
+	FinishedAt     *string `json:"finishedAt,omitempty"`
+	ItemsProcessed int32   `json:"itemsProcessed"`
+	Error          *string `json:"error,omitempty"`
+}
+
+type LinkedIdentity struct {
+	Provider string  `json:"provider"`
+	Email    *string `json:"email,omitempty"`
+	LinkedAt string  `json:"linkedAt"`
+}
+
+type LockoutEvent struct {
+	ID          string  `json:"id"`
+	Kind        string  `json:"kind"`
+	FileID      *string `json:"fileId,omitempty"`
+	FileName    *string `json:"fileName,omitempty"`
+	IPAddress   string  `json:"ipAddress"`
+	Failures    int32   `json:"failures"`
+	LockedUntil string  `json:"lockedUntil"`
+	CreatedAt   string  `json:"createdAt"`
+}
+
 type Mutation struct {
 }
 
+type Notification struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	// Type-specific details as a JSON object.
+	Data      string  `json:"data"`
+	Read      bool    `json:"read"`
+	ReadAt    *string `json:"readAt,omitempty"`
+	CreatedAt string  `json:"createdAt"`
+}
+
 type Query struct {
 }
 
+type ReconcileReport struct {
+	Mode           ReconcileMode `json:"mode"`
+	ObjectsScanned int32         `json:"objectsScanned"`
+	RowsScanned    int32         `json:"rowsScanned"`
+	// Bucket objects with no file row. Only the first 100 keys are listed.
+	OrphanCount   int32    `json:"orphanCount"`
+	OrphanObjects []string `json:"orphanObjects"`
+	// File rows whose object is missing. Only the first 100 paths are listed.
+	MissingCount   int32    `json:"missingCount"`
+	MissingObjects []string `json:"missingObjects"`
+	Deleted        int32    `json:"deleted"`
+	Quarantined    int32    `json:"quarantined"`
+	Errors         []string `json:"errors"`
+}
+
+type Subscription struct {
+}
+
 type User struct {
 	ID              string `json:"id"`
 	Email           string `json:"email"`
 	CreatedAt       string `json:"createdAt"`
 	DownloadAlerts  bool   `json:"downloadAlerts"`
 	ExpiryReminders bool   `json:"expiryReminders"`
+	// Whether downloaders' IP addresses and user agents are kept for your files.
+	DetailedDownloadLogs bool `json:"detailedDownloadLogs"`
+	EmailVerified        bool `json:"emailVerified"`
+	Role                 Role `json:"role"`
 }
 
 type UserStats struct {
-	TotalFiles     int32  `json:"totalFiles"`
-	TotalDownloads int32  `json:"totalDownloads"`
-	StorageUsed    string `json:"storageUsed"`
+	TotalFiles      int32  `json:"totalFiles"`
+	TotalDownloads  int32  `json:"totalDownloads"`
+	StorageUsed     string `json:"storageUsed"`
+	EgressThisMonth string `json:"egressThisMonth"`
+	// Monthly transfer budget, or null when unlimited.
+	EgressBudget *string `json:"egressBudget,omitempty"`
+}
+
+type Webhook struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Active    bool     `json:"active"`
+	CreatedAt string   `json:"createdAt"`
+}
+
+type WebhookDelivery struct {
+	ID             string  `json:"id"`
+	WebhookID      string  `json:"webhookId"`
+	Event          string  `json:"event"`
+	Status         string  `json:"status"`
+	Attempts       int32   `json:"attempts"`
+	ResponseStatus *int32  `json:"responseStatus,omitempty"`
+	ResponseBody   *string `json:"responseBody,omitempty"`
+	LastError      *string `json:"lastError,omitempty"`
+	NextAttemptAt  *string `json:"nextAttemptAt,omitempty"`
+	DeliveredAt    *string `json:"deliveredAt,omitempty"`
+	CreatedAt      string  `json:"createdAt"`
+}
+
+// Returned when a webhook is created or its secret rotated; the secret is only shown here.
+type WebhookWithSecret struct {
+	Webhook *Webhook `json:"webhook"`
+	Secret  string   `json:"secret"`
+}
+
+type AnalyticsGranularity string
+
+const (
+	AnalyticsGranularityHour  AnalyticsGranularity = "HOUR"
+	AnalyticsGranularityDay   AnalyticsGranularity = "DAY"
+	AnalyticsGranularityWeek  AnalyticsGranularity = "WEEK"
+	AnalyticsGranularityMonth AnalyticsGranularity = "MONTH"
+)
+
+var AllAnalyticsGranularity = []AnalyticsGranularity{
+	AnalyticsGranularityHour,
+	AnalyticsGranularityDay,
+	AnalyticsGranularityWeek,
+	AnalyticsGranularityMonth,
+}
+
+func (e AnalyticsGranularity) IsValid() bool {
+	switch e {
+	case AnalyticsGranularityHour, AnalyticsGranularityDay, AnalyticsGranularityWeek, AnalyticsGranularityMonth:
+		return true
+	}
+	return false
+}
+
+func (e AnalyticsGranularity) String() string {
+	return string(e)
+}
+
+func (e *AnalyticsGranularity) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = AnalyticsGranularity(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid AnalyticsGranularity", str)
+	}
+	return nil
+}
+
+func (e AnalyticsGranularity) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *AnalyticsGranularity) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e AnalyticsGranularity) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type ReconcileMode string
+
+const (
+	ReconcileModeDryRun     ReconcileMode = "DRY_RUN"
+	ReconcileModeDelete     ReconcileMode = "DELETE"
+	ReconcileModeQuarantine ReconcileMode = "QUARANTINE"
+)
+
+var AllReconcileMode = []ReconcileMode{
+	ReconcileModeDryRun,
+	ReconcileModeDelete,
+	ReconcileModeQuarantine,
+}
+
+func (e ReconcileMode) IsValid() bool {
+	switch e {
+	case ReconcileModeDryRun, ReconcileModeDelete, ReconcileModeQuarantine:
+		return true
+	}
+	return false
+}
+
+func (e ReconcileMode) String() string {
+	return string(e)
+}
+
+func (e *ReconcileMode) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ReconcileMode(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ReconcileMode", str)
+	}
+	return nil
+}
+
+func (e ReconcileMode) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *ReconcileMode) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e ReconcileMode) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type Role string
+
+const (
+	RoleUser  Role = "USER"
+	RoleAdmin Role = "ADMIN"
+)
+
+var AllRole = []Role{
+	RoleUser,
+	RoleAdmin,
+}
+
+func (e Role) IsValid() bool {
+	switch e {
+	case RoleUser, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+func (e Role) String() string {
+	return string(e)
+}
+
+func (e *Role) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Role(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Role", str)
+	}
+	return nil
+}
+
+func (e Role) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *Role) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e Role) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
 }
@@ -0,0 +1,307 @@
+package resolvers
+
+// This file will be automatically regenerated based on the schema, any resolver implementations
+// will be copied through when generating and any unknown code will be moved to the end.
+// Code generated by github.com/99designs/gqlgen version v0.17.73
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/basit/fileshare-backend/analytics"
+	"github.com/basit/fileshare-backend/audit"
+	"github.com/basit/fileshare-backend/graph/model"
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/jobs"
+	"github.com/basit/fileshare-backend/models"
+)
+
+// AdminSuspendUser is the resolver for the adminSuspendUser field.
+func (r *mutationResolver) AdminSuspendUser(ctx context.Context, userID string) (*model.AdminUser, error) {
+	actorID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setUserSuspended(actorID, userID, true); err != nil {
+		return nil, err
+	}
+
+	audit.RecordFromContext(ctx, audit.Event{
+		ActorID:    actorID,
+		Action:     audit.ActionAdminSuspendUser,
+		TargetType: audit.TargetUser,
+		TargetID:   userID,
+	})
+
+	return loadAdminUser(userID)
+}
+
+// AdminUnsuspendUser is the resolver for the adminUnsuspendUser field.
+func (r *mutationResolver) AdminUnsuspendUser(ctx context.Context, userID string) (*model.AdminUser, error) {
+	actorID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setUserSuspended(actorID, userID, false); err != nil {
+		return nil, err
+	}
+
+	audit.RecordFromContext(ctx, audit.Event{
+		ActorID:    actorID,
+		Action:     audit.ActionAdminUnsuspendUser,
+		TargetType: audit.TargetUser,
+		TargetID:   userID,
+	})
+
+	return loadAdminUser(userID)
+}
+
+// AdminSetUserRole is the resolver for the adminSetUserRole field.
+func (r *mutationResolver) AdminSetUserRole(ctx context.Context, userID string, role model.Role) (*model.AdminUser, error) {
+	actorID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !role.IsValid() {
+		return nil, fmt.Errorf("invalid role")
+	}
+	if actorID.String() == userID && role != model.RoleAdmin {
+		return nil, fmt.Errorf("you can't remove your own admin role")
+	}
+
+	result := initializers.DB.Model(&models.User{}).
+		Where("id = ?", userID).
+		Update("role", roleFromModel(role))
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to update role")
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	audit.RecordFromContext(ctx, audit.Event{
+		ActorID:    actorID,
+		Action:     audit.ActionAdminSetRole,
+		TargetType: audit.TargetUser,
+		TargetID:   userID,
+		Metadata:   map[string]interface{}{"role": roleFromModel(role)},
+	})
+
+	return loadAdminUser(userID)
+}
+
+// AdminExpireFile is the resolver for the adminExpireFile field.
+func (r *mutationResolver) AdminExpireFile(ctx context.Context, fileID string) (*model.AdminFile, error) {
+	actorID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The cleanup job removes the object and row on its next run.
+	result := initializers.DB.Model(&models.File{}).
+		Scopes(models.Uploads).
+		Where("id = ?", fileID).
+		Update("expires_at", time.Now())
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to expire file")
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("file not found")
+	}
+
+	audit.RecordFromContext(ctx, audit.Event{
+		ActorID:    actorID,
+		Action:     audit.ActionAdminExpireFile,
+		TargetType: audit.TargetFile,
+		TargetID:   fileID,
+	})
+
+	return loadAdminFile(fileID)
+}
+
+// AdminRunCleanup is the resolver for the adminRunCleanup field.
+func (r *mutationResolver) AdminRunCleanup(ctx context.Context) (bool, error) {
+	actorID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if err := jobs.RunCleanupNow(); err != nil {
+		return false, err
+	}
+
+	audit.RecordFromContext(ctx, audit.Event{
+		ActorID: actorID,
+		Action:  audit.ActionAdminRunCleanup,
+	})
+
+	return true, nil
+}
+
+// AdminRunJob is the resolver for the adminRunJob field.
+func (r *mutationResolver) AdminRunJob(ctx context.Context, name string) (*model.JobRun, error) {
+	actorID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := jobs.Default.RunNow(ctx, name)
+	if run == nil {
+		return nil, err
+	}
+
+	audit.RecordFromContext(ctx, audit.Event{
+		ActorID:  actorID,
+		Action:   audit.ActionAdminRunJob,
+		Metadata: map[string]interface{}{"job": name, "status": run.Status},
+	})
+
+	// A failed run is still reported; its error is on the run itself.
+	return jobRunToModel(run), nil
+}
+
+// AdminReconcileStorage is the resolver for the adminReconcileStorage field.
+func (r *mutationResolver) AdminReconcileStorage(ctx context.Context, mode model.ReconcileMode) (*model.ReconcileReport, error) {
+	actorID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := jobs.Reconcile(ctx, reconcileModeFromModel(mode))
+	if err != nil {
+		return nil, err
+	}
+
+	audit.RecordFromContext(ctx, audit.Event{
+		ActorID: actorID,
+		Action:  audit.ActionAdminReconcile,
+		Metadata: map[string]interface{}{
+			"mode":        string(report.Mode),
+			"orphans":     report.OrphanCount,
+			"missing":     report.MissingCount,
+			"deleted":     report.Deleted,
+			"quarantined": report.Quarantined,
+		},
+	})
+
+	return reconcileReportToModel(mode, report), nil
+}
+
+// AdminUsers is the resolver for the adminUsers field.
+func (r *queryResolver) AdminUsers(ctx context.Context, search *string, limit *int32, offset *int32) ([]*model.AdminUser, error) {
+	query := adminUsersQuery()
+	if search != nil && *search != "" {
+		query = query.Where("users.email ILIKE ?", containsPattern(*search))
+	}
+
+	var rows []adminUserRow
+	if err := query.
+		Order("users.created_at DESC").
+		Limit(pageLimit(limit, 50, 500)).
+		Offset(pageOffset(offset)).
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch users")
+	}
+
+	result := make([]*model.AdminUser, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, toAdminUser(row))
+	}
+	return result, nil
+}
+
+// AdminFiles is the resolver for the adminFiles field.
+func (r *queryResolver) AdminFiles(ctx context.Context, search *string, ownerID *string, limit *int32, offset *int32) ([]*model.AdminFile, error) {
+	query := adminFilesQuery()
+	if search != nil && *search != "" {
+		query = query.Where("files.original_name ILIKE ? OR files.download_slug = ?", containsPattern(*search), *search)
+	}
+	if ownerID != nil {
+		query = query.Where("files.user_id = ?", *ownerID)
+	}
+
+	var rows []adminFileRow
+	if err := query.
+		Order("files.created_at DESC").
+		Limit(pageLimit(limit, 50, 500)).
+		Offset(pageOffset(offset)).
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch files")
+	}
+
+	result := make([]*model.AdminFile, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, toAdminFile(row))
+	}
+	return result, nil
+}
+
+// AdminJobs is the resolver for the adminJobs field.
+func (r *queryResolver) AdminJobs(ctx context.Context) ([]*model.AdminJob, error) {
+	result := make([]*model.AdminJob, 0)
+	for _, job := range jobs.Default.Jobs() {
+		adminJob := &model.AdminJob{
+			Name:     job.Name,
+			Interval: job.Interval.String(),
+		}
+
+		var runs []models.JobRun
+		if err := initializers.DB.
+			Where("job_name = ?", job.Name).
+			Order("started_at DESC").
+			Limit(1).
+			Find(&runs).Error; err != nil {
+			return nil, fmt.Errorf("failed to fetch job runs")
+		}
+		if len(runs) > 0 {
+			adminJob.LastRun = jobRunToModel(&runs[0])
+		}
+
+		result = append(result, adminJob)
+	}
+
+	return result, nil
+}
+
+// AdminJobRuns is the resolver for the adminJobRuns field.
+func (r *queryResolver) AdminJobRuns(ctx context.Context, jobName *string, status *string, limit *int32, offset *int32) ([]*model.JobRun, error) {
+	query := initializers.DB.Model(&models.JobRun{})
+	if jobName != nil && *jobName != "" {
+		query = query.Where("job_name = ?", *jobName)
+	}
+	if status != nil && *status != "" {
+		query = query.Where("status = ?", *status)
+	}
+
+	var runs []models.JobRun
+	if err := query.
+		Order("started_at DESC").
+		Limit(pageLimit(limit, 50, 200)).
+		Offset(pageOffset(offset)).
+		Find(&runs).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch job runs")
+	}
+
+	result := make([]*model.JobRun, 0, len(runs))
+	for i := range runs {
+		result = append(result, jobRunToModel(&runs[i]))
+	}
+
+	return result, nil
+}
+
+// AdminEventRecorderStats is the resolver for the adminEventRecorderStats field.
+func (r *queryResolver) AdminEventRecorderStats(ctx context.Context) (*model.EventRecorderStats, error) {
+	stats := analytics.Events.Stats()
+	return &model.EventRecorderStats{
+		Queued:        int32(stats.Queued),
+		Capacity:      int32(stats.Capacity),
+		Recorded:      int32(stats.Recorded),
+		Dropped:       int32(stats.Dropped),
+		FailedBatches: int32(stats.FailedBatches),
+	}, nil
+}
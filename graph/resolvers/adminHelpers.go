@@ -0,0 +1,219 @@
+package resolvers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/basit/fileshare-backend/graph/model"
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/jobs"
+	"github.com/basit/fileshare-backend/models"
+)
+
+type adminUserRow struct {
+	models.User
+	FileCount    int64
+	StorageBytes int64
+}
+
+type adminFileRow struct {
+	models.File
+	OwnerEmail *string
+}
+
+// likeEscaper escapes the LIKE wildcards, and the backslash that escapes
+// them, so a search matches literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// containsPattern is the ILIKE pattern matching values that contain search.
+func containsPattern(search string) string {
+	return "%" + likeEscaper.Replace(search) + "%"
+}
+
+func adminUsersQuery() *gorm.DB {
+	return initializers.DB.
+		Table("users").
+		Select("users.*, COUNT(files.id) AS file_count, COALESCE(SUM(files.file_size), 0) AS storage_bytes").
+		Joins("LEFT JOIN files ON files.user_id = users.id AND " + models.UploadCondition).
+		Group("users.id")
+}
+
+func adminFilesQuery() *gorm.DB {
+	return initializers.DB.
+		Table("files").
+		Select("files.*, users.email AS owner_email").
+		Joins("LEFT JOIN users ON users.id = files.user_id").
+		Scopes(models.Uploads)
+}
+
+func loadAdminUser(id string) (*model.AdminUser, error) {
+	var row adminUserRow
+	if err := adminUsersQuery().Where("users.id = ?", id).Take(&row).Error; err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	return toAdminUser(row), nil
+}
+
+func loadAdminFile(id string) (*model.AdminFile, error) {
+	var row adminFileRow
+	if err := adminFilesQuery().Where("files.id = ?", id).Take(&row).Error; err != nil {
+		return nil, fmt.Errorf("file not found")
+	}
+	return toAdminFile(row), nil
+}
+
+func toAdminUser(row adminUserRow) *model.AdminUser {
+	var suspendedAt *string
+	if row.SuspendedAt != nil {
+		s := row.SuspendedAt.String()
+		suspendedAt = &s
+	}
+	return &model.AdminUser{
+		ID:            row.ID.String(),
+		Email:         row.Email,
+		Role:          roleToModel(row.Role),
+		EmailVerified: row.EmailVerified,
+		Suspended:     row.IsSuspended(),
+		SuspendedAt:   suspendedAt,
+		CreatedAt:     row.CreatedAt.String(),
+		FileCount:     int32(row.FileCount),
+		StorageUsed:   formatSize(row.StorageBytes),
+	}
+}
+
+func toAdminFile(row adminFileRow) *model.AdminFile {
+	var ownerID, expiresAt *string
+	if row.UserID != nil {
+		id := row.UserID.String()
+		ownerID = &id
+	}
+	if row.ExpiresAt != nil {
+		e := row.ExpiresAt.String()
+		expiresAt = &e
+	}
+	var cleanupLastError *string
+	if row.CleanupLastError != "" {
+		cleanupLastError = &row.CleanupLastError
+	}
+
+	var integrityStatus, integrityCheckedAt, integrityDetail *string
+	if row.IntegrityStatus != "" {
+		integrityStatus = &row.IntegrityStatus
+	}
+	if row.IntegrityCheckedAt != nil {
+		checked := row.IntegrityCheckedAt.String()
+		integrityCheckedAt = &checked
+	}
+	if row.IntegrityDetail != "" {
+		integrityDetail = &row.IntegrityDetail
+	}
+
+	return &model.AdminFile{
+		ID:               row.ID.String(),
+		OriginalName:     row.OriginalName,
+		OwnerID:          ownerID,
+		OwnerEmail:       row.OwnerEmail,
+		FileSize:         row.FileSize,
+		DownloadCount:    int32(row.DownloadCount),
+		IsPublic:         row.IsPublic,
+		CreatedAt:        row.CreatedAt.String(),
+		ExpiresAt:        expiresAt,
+		CleanupFailures:  int32(row.CleanupFailures),
+		CleanupLastError: cleanupLastError,
+
+		IntegrityStatus:    integrityStatus,
+		IntegrityCheckedAt: integrityCheckedAt,
+		IntegrityDetail:    integrityDetail,
+	}
+}
+
+func roleToModel(role string) model.Role {
+	return model.Role(strings.ToUpper(role))
+}
+
+func roleFromModel(role model.Role) string {
+	return strings.ToLower(string(role))
+}
+
+// setUserSuspended suspends or reinstates a user. Admins can't suspend
+// themselves.
+func setUserSuspended(actorID *uuid.UUID, userID string, suspended bool) error {
+	if actorID != nil && actorID.String() == userID && suspended {
+		return fmt.Errorf("you can't suspend your own account")
+	}
+
+	var suspendedAt *time.Time
+	if suspended {
+		now := time.Now()
+		suspendedAt = &now
+	}
+
+	result := initializers.DB.Model(&models.User{}).
+		Where("id = ?", userID).
+		Update("suspended_at", suspendedAt)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update user: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+func jobRunToModel(run *models.JobRun) *model.JobRun {
+	result := &model.JobRun{
+		ID:             run.ID.String(),
+		JobName:        run.JobName,
+		Trigger:        run.Trigger,
+		Status:         run.Status,
+		StartedAt:      run.StartedAt.String(),
+		ItemsProcessed: int32(run.ItemsProcessed),
+	}
+	if run.Instance != "" {
+		result.Instance = &run.Instance
+	}
+	if run.FinishedAt != nil {
+		finished := run.FinishedAt.String()
+		result.FinishedAt = &finished
+	}
+	if run.Error != "" {
+		result.Error = &run.Error
+	}
+	return result
+}
+
+func reconcileModeFromModel(mode model.ReconcileMode) jobs.ReconcileMode {
+	switch mode {
+	case model.ReconcileModeDelete:
+		return jobs.ReconcileDelete
+	case model.ReconcileModeQuarantine:
+		return jobs.ReconcileQuarantine
+	}
+	return jobs.ReconcileDryRun
+}
+
+func reconcileReportToModel(mode model.ReconcileMode, report *jobs.ReconcileReport) *model.ReconcileReport {
+	return &model.ReconcileReport{
+		Mode:           mode,
+		ObjectsScanned: int32(report.ObjectsScanned),
+		RowsScanned:    int32(report.RowsScanned),
+		OrphanCount:    int32(report.OrphanCount),
+		OrphanObjects:  nonNilStrings(report.OrphanObjects),
+		MissingCount:   int32(report.MissingCount),
+		MissingObjects: nonNilStrings(report.MissingObjects),
+		Deleted:        int32(report.Deleted),
+		Quarantined:    int32(report.Quarantined),
+		Errors:         nonNilStrings(report.Errors),
+	}
+}
+
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,87 @@
+package resolvers
+
+// This file will be automatically regenerated based on the schema, any resolver implementations
+// will be copied through when generating and any unknown code will be moved to the end.
+// Code generated by github.com/99designs/gqlgen version v0.17.73
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/basit/fileshare-backend/analytics"
+	"github.com/basit/fileshare-backend/graph/model"
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+)
+
+// FileAnalytics is the resolver for the fileAnalytics field.
+func (r *queryResolver) FileAnalytics(ctx context.Context, fileID string, from *string, to *string, granularity *model.AnalyticsGranularity) (*model.FileAnalytics, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var file models.File
+	query := initializers.DB.Select("id").Scopes(models.Uploads).Where("id = ?", fileID)
+	if GetUserRoleFromContext(ctx) != models.RoleAdmin {
+		query = query.Where("user_id = ?", userID)
+	}
+	if err := query.First(&file).Error; err != nil {
+		return nil, fmt.Errorf("file not found")
+	}
+
+	start, end, g, err := parseAnalyticsRange(from, to, granularity)
+	if err != nil {
+		return nil, err
+	}
+	anonymizedBefore, err := analytics.AnonymizedBefore()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load analytics")
+	}
+	window := analyticsRange{fileID: file.ID, from: start, to: end, granularity: g, anonymizedBefore: anonymizedBefore}
+
+	total, unique, err := downloadTotals(window)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load analytics")
+	}
+	series, err := downloadSeries(window)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load analytics")
+	}
+
+	result := &model.FileAnalytics{
+		FileID:            file.ID.String(),
+		From:              start.Format(time.RFC3339),
+		To:                end.Format(time.RFC3339),
+		Granularity:       g,
+		TotalDownloads:    int32(total),
+		UniqueDownloaders: int32(unique),
+		Series:            series,
+	}
+
+	breakdowns := []struct {
+		dimension string
+		dst       *[]*model.AnalyticsCount
+	}{
+		{analytics.DimUserAgent, &result.TopUserAgents},
+		{analytics.DimBrowser, &result.Browsers},
+		{analytics.DimOS, &result.OperatingSystems},
+		{analytics.DimReferrer, &result.Referrers},
+		{analytics.DimCountry, &result.Countries},
+		{analytics.DimCity, &result.Cities},
+		{analytics.DimDevice, &result.Devices},
+	}
+	for _, b := range breakdowns {
+		if *b.dst, err = topValues(window, b.dimension); err != nil {
+			return nil, fmt.Errorf("failed to load analytics")
+		}
+	}
+	for _, device := range result.Devices {
+		if device.Value == analytics.DeviceBot {
+			result.BotDownloads = device.Count
+		}
+	}
+
+	return result, nil
+}
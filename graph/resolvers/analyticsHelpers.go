@@ -0,0 +1,257 @@
+package resolvers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/basit/fileshare-backend/analytics"
+	"github.com/basit/fileshare-backend/graph/model"
+	"github.com/basit/fileshare-backend/initializers"
+)
+
+const (
+	analyticsDefaultRange = 30 * 24 * time.Hour
+	analyticsMaxBuckets   = 2000
+	analyticsTopN         = 10
+)
+
+// analyticsRange is the window an analytics query covers. Hourly queries
+// read raw download events, which are only kept for the retention period;
+// everything else reads the daily rollups, so the window is widened to
+// whole UTC days. Rollup days before anonymizedBefore have no per-downloader
+// rows, so their daily unique counts are added up instead.
+type analyticsRange struct {
+	fileID           uuid.UUID
+	from, to         time.Time
+	granularity      model.AnalyticsGranularity
+	anonymizedBefore time.Time
+}
+
+func (r analyticsRange) fromRollups() bool {
+	return r.granularity != model.AnalyticsGranularityHour
+}
+
+// days returns the window as dates, for comparing with rollup days.
+func (r analyticsRange) days() (string, string) {
+	return r.from.Format("2006-01-02"), r.to.Format("2006-01-02")
+}
+
+func (r analyticsRange) args() map[string]interface{} {
+	unit, _ := granularityUnit(r.granularity)
+	return map[string]interface{}{
+		"unit": unit,
+		"from": r.from,
+		"to":   r.to,
+		"file": r.fileID,
+		"anon": r.anonymizedBefore.Format("2006-01-02"),
+	}
+}
+
+func granularityUnit(g model.AnalyticsGranularity) (string, time.Duration) {
+	switch g {
+	case model.AnalyticsGranularityHour:
+		return "hour", time.Hour
+	case model.AnalyticsGranularityWeek:
+		return "week", 7 * 24 * time.Hour
+	case model.AnalyticsGranularityMonth:
+		return "month", 30 * 24 * time.Hour
+	}
+	return "day", 24 * time.Hour
+}
+
+func parseAnalyticsRange(from, to *string, granularity *model.AnalyticsGranularity) (time.Time, time.Time, model.AnalyticsGranularity, error) {
+	end := time.Now().UTC()
+	if to != nil && *to != "" {
+		t, err := time.Parse(time.RFC3339, *to)
+		if err != nil {
+			return time.Time{}, time.Time{}, "", fmt.Errorf("invalid to: expected RFC 3339")
+		}
+		end = t.UTC()
+	}
+
+	start := end.Add(-analyticsDefaultRange)
+	if from != nil && *from != "" {
+		t, err := time.Parse(time.RFC3339, *from)
+		if err != nil {
+			return time.Time{}, time.Time{}, "", fmt.Errorf("invalid from: expected RFC 3339")
+		}
+		start = t.UTC()
+	}
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, "", fmt.Errorf("from must be before to")
+	}
+
+	g := model.AnalyticsGranularityDay
+	if granularity != nil {
+		g = *granularity
+	}
+	if g != model.AnalyticsGranularityHour {
+		start = analytics.Day(start)
+		end = analytics.Day(end.Add(-time.Nanosecond)).AddDate(0, 0, 1)
+	}
+	if _, step := granularityUnit(g); end.Sub(start)/step > analyticsMaxBuckets {
+		return time.Time{}, time.Time{}, "", fmt.Errorf("range too large for %s granularity", strings.ToLower(string(g)))
+	}
+
+	return start, end, g, nil
+}
+
+type seriesRow struct {
+	Start             time.Time
+	Downloads         int64
+	UniqueDownloaders int64
+}
+
+// downloadSeries counts downloads per bucket, including empty buckets.
+func downloadSeries(r analyticsRange) ([]*model.AnalyticsBucket, error) {
+	var rows []seriesRow
+	var err error
+	if r.fromRollups() {
+		err = initializers.DB.Raw(`
+SELECT b.bucket AS start,
+	COALESCE(s.downloads, 0) AS downloads,
+	COALESCE(u.unique_downloaders, 0) AS unique_downloaders
+FROM generate_series(
+	date_trunc(@unit, CAST(@from AS timestamptz) AT TIME ZONE 'UTC'),
+	date_trunc(@unit, (CAST(@to AS timestamptz) - interval '1 day') AT TIME ZONE 'UTC'),
+	CAST('1 ' || @unit AS interval)
+) AS b(bucket)
+LEFT JOIN LATERAL (
+	SELECT SUM(downloads) AS downloads
+	FROM file_daily_stats
+	WHERE file_id = @file
+		AND day >= b.bucket AND day < b.bucket + CAST('1 ' || @unit AS interval)
+		AND day >= CAST(@from AS timestamptz) AT TIME ZONE 'UTC'
+		AND day < CAST(@to AS timestamptz) AT TIME ZONE 'UTC'
+) s ON true
+LEFT JOIN LATERAL (
+	SELECT (
+		SELECT COUNT(DISTINCT downloader_hash)
+		FROM file_daily_downloaders
+		WHERE file_id = @file
+			AND day >= b.bucket AND day < b.bucket + CAST('1 ' || @unit AS interval)
+			AND day >= CAST(@from AS timestamptz) AT TIME ZONE 'UTC'
+			AND day < CAST(@to AS timestamptz) AT TIME ZONE 'UTC'
+			AND day >= CAST(@anon AS date)
+	) + (
+		SELECT COALESCE(SUM(unique_downloaders), 0)
+		FROM file_daily_stats
+		WHERE file_id = @file
+			AND day >= b.bucket AND day < b.bucket + CAST('1 ' || @unit AS interval)
+			AND day >= CAST(@from AS timestamptz) AT TIME ZONE 'UTC'
+			AND day < CAST(@to AS timestamptz) AT TIME ZONE 'UTC'
+			AND day < CAST(@anon AS date)
+	) AS unique_downloaders
+) u ON true
+ORDER BY b.bucket`, r.args()).Scan(&rows).Error
+	} else {
+		err = initializers.DB.Raw(`
+SELECT b.bucket AS start,
+	COUNT(e.id) AS downloads,
+	COUNT(DISTINCT COALESCE(e.user_id::text, NULLIF(e.ip_address, ''))) AS unique_downloaders
+FROM generate_series(
+	date_trunc(@unit, CAST(@from AS timestamptz) AT TIME ZONE 'UTC'),
+	date_trunc(@unit, (CAST(@to AS timestamptz) - interval '1 microsecond') AT TIME ZONE 'UTC'),
+	CAST('1 ' || @unit AS interval)
+) AS b(bucket)
+LEFT JOIN download_events e
+	ON e.file_id = @file
+	AND e.created_at >= @from AND e.created_at < @to
+	AND date_trunc(@unit, e.created_at AT TIME ZONE 'UTC') = b.bucket
+GROUP BY b.bucket
+ORDER BY b.bucket`, r.args()).Scan(&rows).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	series := make([]*model.AnalyticsBucket, 0, len(rows))
+	for _, row := range rows {
+		series = append(series, &model.AnalyticsBucket{
+			Start:             row.Start.UTC().Format(time.RFC3339),
+			Downloads:         int32(row.Downloads),
+			UniqueDownloaders: int32(row.UniqueDownloaders),
+		})
+	}
+	return series, nil
+}
+
+func downloadTotals(r analyticsRange) (int64, int64, error) {
+	var downloads, uniques int64
+
+	if !r.fromRollups() {
+		var totals struct {
+			Downloads         int64
+			UniqueDownloaders int64
+		}
+		err := initializers.DB.
+			Table("download_events").
+			Select("COUNT(*) AS downloads, COUNT(DISTINCT "+analytics.DownloaderExpr+") AS unique_downloaders").
+			Where("file_id = ? AND created_at >= ? AND created_at < ?", r.fileID, r.from, r.to).
+			Scan(&totals).Error
+		return totals.Downloads, totals.UniqueDownloaders, err
+	}
+
+	from, to := r.days()
+	if err := initializers.DB.
+		Table("file_daily_stats").
+		Select("COALESCE(SUM(downloads), 0)").
+		Where("file_id = ? AND day >= ? AND day < ?", r.fileID, from, to).
+		Scan(&downloads).Error; err != nil {
+		return 0, 0, err
+	}
+	anon := r.anonymizedBefore.Format("2006-01-02")
+	if err := initializers.DB.
+		Table("file_daily_downloaders").
+		Select("COUNT(DISTINCT downloader_hash)").
+		Where("file_id = ? AND day >= ? AND day < ? AND day >= ?", r.fileID, from, to, anon).
+		Scan(&uniques).Error; err != nil {
+		return 0, 0, err
+	}
+	var anonymizedUniques int64
+	err := initializers.DB.
+		Table("file_daily_stats").
+		Select("COALESCE(SUM(unique_downloaders), 0)").
+		Where("file_id = ? AND day >= ? AND day < ? AND day < ?", r.fileID, from, to, anon).
+		Scan(&anonymizedUniques).Error
+	return downloads, uniques + anonymizedUniques, err
+}
+
+// topValues returns the most common values of a breakdown dimension.
+func topValues(r analyticsRange, dimension string) ([]*model.AnalyticsCount, error) {
+	var rows []struct {
+		Value string
+		Count int64
+	}
+
+	query := initializers.DB
+	if r.fromRollups() {
+		from, to := r.days()
+		query = query.
+			Table("file_daily_breakdowns").
+			Select("value, SUM(count) AS count").
+			Where("file_id = ? AND dimension = ? AND day >= ? AND day < ?", r.fileID, dimension, from, to)
+	} else {
+		query = query.
+			Table("download_events").
+			Select(analytics.Dimensions[dimension]+" AS value, COUNT(*) AS count").
+			Where("file_id = ? AND created_at >= ? AND created_at < ?", r.fileID, r.from, r.to)
+	}
+	err := query.
+		Group("value").
+		Order("count DESC, value").
+		Limit(analyticsTopN).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.AnalyticsCount, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, &model.AnalyticsCount{Value: row.Value, Count: int32(row.Count)})
+	}
+	return result, nil
+}
@@ -0,0 +1,41 @@
+package resolvers
+
+// This file will be automatically regenerated based on the schema, any resolver implementations
+// will be copied through when generating and any unknown code will be moved to the end.
+// Code generated by github.com/99designs/gqlgen version v0.17.73
+
+import (
+	"context"
+
+	"github.com/basit/fileshare-backend/audit"
+	"github.com/basit/fileshare-backend/graph/model"
+)
+
+// MyAuditEvents is the resolver for the myAuditEvents field.
+func (r *queryResolver) MyAuditEvents(ctx context.Context, action *string, limit *int32, offset *int32) ([]*model.AuditEvent, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Include actions taken on the account by others, such as an admin
+	// suspending it.
+	query := auditEventsQuery().
+		Where("audit_events.actor_id = ? OR (audit_events.target_type = ? AND audit_events.target_id = ?)",
+			userID, audit.TargetUser, userID.String())
+	if action != nil {
+		query = query.Where("audit_events.action = ?", *action)
+	}
+
+	return findAuditEvents(query, limit, offset)
+}
+
+// AdminAuditEvents is the resolver for the adminAuditEvents field.
+func (r *queryResolver) AdminAuditEvents(ctx context.Context, filter *model.AuditEventFilter, limit *int32, offset *int32) ([]*model.AuditEvent, error) {
+	query, err := applyAuditFilter(auditEventsQuery(), filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return findAuditEvents(query, limit, offset)
+}
@@ -0,0 +1,96 @@
+package resolvers
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/basit/fileshare-backend/graph/model"
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+)
+
+type auditEventRow struct {
+	models.AuditEvent
+	ActorEmail *string
+}
+
+func auditEventsQuery() *gorm.DB {
+	return initializers.DB.
+		Table("audit_events").
+		Select("audit_events.*, users.email AS actor_email").
+		Joins("LEFT JOIN users ON users.id = audit_events.actor_id")
+}
+
+func applyAuditFilter(query *gorm.DB, filter *model.AuditEventFilter) (*gorm.DB, error) {
+	if filter == nil {
+		return query, nil
+	}
+	if filter.ActorID != nil {
+		query = query.Where("audit_events.actor_id = ?", *filter.ActorID)
+	}
+	if filter.Action != nil {
+		query = query.Where("audit_events.action = ?", *filter.Action)
+	}
+	if filter.TargetType != nil {
+		query = query.Where("audit_events.target_type = ?", *filter.TargetType)
+	}
+	if filter.TargetID != nil {
+		query = query.Where("audit_events.target_id = ?", *filter.TargetID)
+	}
+	if filter.From != nil {
+		from, err := time.Parse(time.RFC3339, *filter.From)
+		if err != nil {
+			return nil, fmt.Errorf("from must be an RFC 3339 timestamp")
+		}
+		query = query.Where("audit_events.created_at >= ?", from)
+	}
+	if filter.To != nil {
+		to, err := time.Parse(time.RFC3339, *filter.To)
+		if err != nil {
+			return nil, fmt.Errorf("to must be an RFC 3339 timestamp")
+		}
+		query = query.Where("audit_events.created_at < ?", to)
+	}
+	return query, nil
+}
+
+func findAuditEvents(query *gorm.DB, limit, offset *int32) ([]*model.AuditEvent, error) {
+	var rows []auditEventRow
+	if err := query.
+		Order("audit_events.created_at DESC").
+		Limit(pageLimit(limit, 50, 500)).
+		Offset(pageOffset(offset)).
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch audit events")
+	}
+
+	result := make([]*model.AuditEvent, 0, len(rows))
+	for _, row := range rows {
+		event := &model.AuditEvent{
+			ID:         row.ID.String(),
+			ActorEmail: row.ActorEmail,
+			Action:     row.Action,
+			TargetType: optionalString(row.TargetType),
+			TargetID:   optionalString(row.TargetID),
+			IPAddress:  optionalString(row.IPAddress),
+			UserAgent:  optionalString(row.UserAgent),
+			Metadata:   row.Metadata,
+			CreatedAt:  row.CreatedAt.String(),
+		}
+		if row.ActorID != nil {
+			actorID := row.ActorID.String()
+			event.ActorID = &actorID
+		}
+		result = append(result, event)
+	}
+	return result, nil
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -7,9 +7,11 @@ package resolvers
 import (
 	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
+	"github.com/basit/fileshare-backend/audit"
 	"github.com/basit/fileshare-backend/auth"
 	"github.com/basit/fileshare-backend/graph/model"
 	"github.com/basit/fileshare-backend/initializers"
@@ -17,13 +19,26 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 // Register is the resolver for the register field.
 func (r *mutationResolver) Register(ctx context.Context, email string, password string) (*model.AuthPayload, error) {
+	if err := checkLoginRateLimit(ctx); err != nil {
+		return nil, err
+	}
+
+	email = auth.NormalizeEmail(email)
+	if err := validateEmail(email); err != nil {
+		return nil, err
+	}
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+
 	// Check if user exists
 	var existing models.User
-	if err := initializers.DB.Where("email = ?", email).First(&existing).Error; err == nil {
+	if err := initializers.DB.Where("LOWER(email) = ?", email).First(&existing).Error; err == nil {
 		return nil, fmt.Errorf("email already registered")
 	}
 	// Hash password
@@ -45,6 +60,10 @@ func (r *mutationResolver) Register(ctx context.Context, email string, password
 		return nil, fmt.Errorf("failed to create user: %v", err)
 	}
 
+	if err := sendVerificationEmail(ctx, &user); err != nil {
+		log.Printf("Verification email failed for user %s: %v", user.ID, err)
+	}
+
 	// Generate JWT
 	accessToken, refreshToken, err := auth.GenerateTokens(user.ID.String())
 	if err != nil {
@@ -68,24 +87,55 @@ func (r *mutationResolver) Register(ctx context.Context, email string, password
 	return &model.AuthPayload{
 		AccessToken: accessToken,
 		User: &model.User{
-			ID:    user.ID.String(),
-			Email: user.Email,
+			ID:            user.ID.String(),
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+			Role:          roleToModel(user.Role),
 		},
 	}, nil
 }
 
 // Login is the resolver for the login field.
 func (r *mutationResolver) Login(ctx context.Context, email string, password string) (*model.AuthPayload, error) {
+	if err := checkLoginRateLimit(ctx); err != nil {
+		return nil, err
+	}
+
+	email = auth.NormalizeEmail(email)
+	throttleKey := auth.LoginThrottleKey(email)
+	if err := auth.CheckLockout(throttleKey); err != nil {
+		return nil, err
+	}
+
+	// Accounts registered before emails were normalised may be stored in
+	// mixed case.
 	var user models.User
-	if err := initializers.DB.Where("email = ?", email).First(&user).Error; err != nil {
+	if err := initializers.DB.Where("LOWER(email) = ?", email).First(&user).Error; err != nil {
+		registerLoginFailure(ctx, throttleKey, nil)
 		return nil, fmt.Errorf("invalid email or password")
 	}
 
 	// Compare password using bcrypt (or whatever you use)
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		registerLoginFailure(ctx, throttleKey, &user.ID)
+		// Whoever failed to log in isn't known to be the account owner, so
+		// the account is only the target. The client IP comes from ctx.
+		audit.RecordFromContext(ctx, audit.Event{
+			Action:     audit.ActionLoginFailed,
+			TargetType: audit.TargetUser,
+			TargetID:   user.ID.String(),
+		})
 		return nil, fmt.Errorf("invalid email or password")
 	}
 
+	if err := auth.ResetFailures(throttleKey); err != nil {
+		log.Printf("Failed to reset login attempts for %s: %v", user.ID, err)
+	}
+
+	if user.IsSuspended() {
+		return nil, auth.ErrSuspended
+	}
+
 	accessToken, refreshToken, err := auth.GenerateTokens(user.ID.String())
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate tokens: %v", err)
@@ -106,11 +156,21 @@ func (r *mutationResolver) Login(ctx context.Context, email string, password str
 		Expires:  time.Now().Add(30 * 24 * time.Hour),
 	})
 
+	audit.RecordFromContext(ctx, audit.Event{
+		ActorID:    &user.ID,
+		Action:     audit.ActionLogin,
+		TargetType: audit.TargetUser,
+		TargetID:   user.ID.String(),
+		Metadata:   map[string]interface{}{"method": "password"},
+	})
+
 	return &model.AuthPayload{
 		AccessToken: accessToken,
 		User: &model.User{
-			ID:    user.ID.String(),
-			Email: user.Email,
+			ID:            user.ID.String(),
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+			Role:          roleToModel(user.Role),
 			// Add other fields as needed
 		},
 	}, nil
@@ -128,12 +188,21 @@ func (r *mutationResolver) RefreshToken(ctx context.Context, token string) (*mod
 		return nil, fmt.Errorf("refresh token not found")
 	}
 
-	userID, err := auth.ValidateToken(cookie.Value)
+	userID, err := auth.ValidateRefreshToken(cookie.Value)
 
 	if err != nil {
 		return nil, fmt.Errorf("invalid refresh token")
 	}
 
+	var user models.User
+	if err := initializers.DB.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	if user.IsSuspended() {
+		return nil, auth.ErrSuspended
+	}
+
 	accessToken, newRefreshToken, err := auth.GenerateTokens(userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate new tokens")
@@ -149,17 +218,127 @@ func (r *mutationResolver) RefreshToken(ctx context.Context, token string) (*mod
 		Expires:  time.Now().Add(30 * 24 * time.Hour),
 	})
 
-	var user models.User
-	if err := initializers.DB.First(&user, "id = ?", userID).Error; err != nil {
-		return nil, fmt.Errorf("user not found")
-	}
+	audit.RecordFromContext(ctx, audit.Event{
+		ActorID:    &user.ID,
+		Action:     audit.ActionTokenRefresh,
+		TargetType: audit.TargetUser,
+		TargetID:   user.ID.String(),
+	})
 
 	return &model.AuthPayload{
 		AccessToken: accessToken,
 		User: &model.User{
-			ID:    user.ID.String(),
-			Email: user.Email,
+			ID:            user.ID.String(),
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+			Role:          roleToModel(user.Role),
 			// Add other fields as needed
 		},
 	}, nil
 }
+
+// VerifyEmail is the resolver for the verifyEmail field.
+func (r *mutationResolver) VerifyEmail(ctx context.Context, token string) (bool, error) {
+	userToken, err := auth.ConsumeUserToken(models.TokenPurposeEmailVerification, token)
+	if err != nil {
+		return false, err
+	}
+
+	if err := initializers.DB.Model(&models.User{}).
+		Where("id = ?", userToken.UserID).
+		Updates(map[string]interface{}{
+			"email_verified":    true,
+			"email_verified_at": time.Now(),
+		}).Error; err != nil {
+		return false, fmt.Errorf("failed to verify email: %v", err)
+	}
+
+	return true, nil
+}
+
+// ResendVerificationEmail is the resolver for the resendVerificationEmail field.
+func (r *mutationResolver) ResendVerificationEmail(ctx context.Context) (bool, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var user models.User
+	if err := initializers.DB.First(&user, "id = ?", userID).Error; err != nil {
+		return false, fmt.Errorf("user not found")
+	}
+
+	if user.EmailVerified {
+		return true, nil
+	}
+
+	if err := sendVerificationEmail(ctx, &user); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RequestPasswordReset is the resolver for the requestPasswordReset field.
+func (r *mutationResolver) RequestPasswordReset(ctx context.Context, email string) (bool, error) {
+	if err := checkLoginRateLimit(ctx); err != nil {
+		return false, err
+	}
+
+	// Always report success so the endpoint can't be used to discover
+	// which emails are registered.
+	var user models.User
+	if err := initializers.DB.Where("LOWER(email) = ?", auth.NormalizeEmail(email)).First(&user).Error; err != nil {
+		return true, nil
+	}
+
+	if err := sendPasswordResetEmail(ctx, &user); err != nil {
+		log.Printf("Password reset email failed for user %s: %v", user.ID, err)
+	}
+
+	return true, nil
+}
+
+// ResetPassword is the resolver for the resetPassword field.
+func (r *mutationResolver) ResetPassword(ctx context.Context, token string, newPassword string) (bool, error) {
+	if err := checkLoginRateLimit(ctx); err != nil {
+		return false, err
+	}
+
+	// Checked first so a rejected password doesn't use up the link.
+	if err := validatePassword(newPassword); err != nil {
+		return false, err
+	}
+
+	userToken, err := auth.ConsumeUserToken(models.TokenPurposePasswordReset, token)
+	if err != nil {
+		return false, err
+	}
+
+	newHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return false, fmt.Errorf("failed to hash new password")
+	}
+
+	// Following the emailed link proves ownership of the address, so the
+	// account is verified as well. Sessions from before the reset end.
+	if err := initializers.DB.Model(&models.User{}).
+		Where("id = ?", userToken.UserID).
+		Updates(map[string]interface{}{
+			"password_hash":       string(newHash),
+			"password_changed_at": time.Now(),
+			"email_verified":      true,
+			"email_verified_at":   gorm.Expr("COALESCE(email_verified_at, ?)", time.Now()),
+		}).Error; err != nil {
+		return false, fmt.Errorf("failed to update password")
+	}
+
+	audit.RecordFromContext(ctx, audit.Event{
+		ActorID:    &userToken.UserID,
+		Action:     audit.ActionPasswordReset,
+		TargetType: audit.TargetUser,
+		TargetID:   userToken.UserID.String(),
+	})
+
+	return true, nil
+}
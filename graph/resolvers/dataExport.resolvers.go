@@ -0,0 +1,67 @@
+package resolvers
+
+// This file will be automatically regenerated based on the schema, any resolver implementations
+// will be copied through when generating and any unknown code will be moved to the end.
+// Code generated by github.com/99designs/gqlgen version v0.17.73
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/basit/fileshare-backend/audit"
+	"github.com/basit/fileshare-backend/graph/model"
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/jobs"
+	"github.com/basit/fileshare-backend/models"
+)
+
+// RequestDataExport is the resolver for the requestDataExport field.
+func (r *mutationResolver) RequestDataExport(ctx context.Context) (*model.DataExport, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	exp, err := jobs.RequestDataExport(*userID)
+	if errors.Is(err, jobs.ErrDataExportInProgress) || errors.Is(err, jobs.ErrDataExportTooSoon) {
+		return nil, err
+	}
+	if err != nil {
+		log.Printf("Failed to queue data export for user %s: %v", userID, err)
+		return nil, fmt.Errorf("failed to request data export")
+	}
+
+	audit.RecordFromContext(ctx, audit.Event{
+		ActorID:    userID,
+		Action:     audit.ActionTakeoutRequest,
+		TargetType: audit.TargetUser,
+		TargetID:   userID.String(),
+	})
+
+	return dataExportToModel(exp), nil
+}
+
+// DataExports is the resolver for the dataExports field.
+func (r *queryResolver) DataExports(ctx context.Context) ([]*model.DataExport, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var exports []models.DataExport
+	if err := initializers.DB.
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(20).
+		Find(&exports).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch data exports")
+	}
+
+	result := make([]*model.DataExport, 0, len(exports))
+	for i := range exports {
+		result = append(result, dataExportToModel(&exports[i]))
+	}
+	return result, nil
+}
@@ -0,0 +1,25 @@
+package resolvers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql"
+
+	"github.com/basit/fileshare-backend/graph/model"
+)
+
+// HasRoleDirective implements @hasRole. ADMIN satisfies any role check.
+func HasRoleDirective(ctx context.Context, obj any, next graphql.Resolver, role model.Role) (any, error) {
+	if _, err := GetUserIDFromContext(ctx); err != nil {
+		return nil, err
+	}
+
+	current := model.Role(strings.ToUpper(GetUserRoleFromContext(ctx)))
+	if current != role && current != model.RoleAdmin {
+		return nil, fmt.Errorf("forbidden")
+	}
+
+	return next(ctx)
+}
@@ -0,0 +1,222 @@
+package resolvers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/mail"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/basit/fileshare-backend/auth"
+	"github.com/basit/fileshare-backend/graph/model"
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/jobs"
+	"github.com/basit/fileshare-backend/mailer"
+	"github.com/basit/fileshare-backend/models"
+	"github.com/basit/fileshare-backend/ratelimit"
+)
+
+func deleteUserFilesFromS3(userID string) error {
+	var files []models.File
+	if err := initializers.DB.Where("user_id = ?", userID).Find(&files).Error; err != nil {
+		return fmt.Errorf("failed to fetch user files: %w", err)
+	}
+
+	s3Client := initializers.S3Client
+	var deleteErrors []error
+
+	for _, file := range files {
+		_, err := s3Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+			Bucket: aws.String(initializers.S3Bucket),
+			Key:    aws.String(file.StoragePath), // This is now the S3 key after your fix
+		})
+		if err != nil {
+			log.Printf("Failed to delete S3 object %s: %v", file.StoragePath, err)
+			deleteErrors = append(deleteErrors, err)
+		}
+	}
+
+	if len(deleteErrors) > 0 {
+		return fmt.Errorf("failed to delete %d files from S3", len(deleteErrors))
+	}
+
+	return nil
+}
+
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// Password length limits. bcrypt ignores everything past 72 bytes.
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+// validatePassword applies the password policy to a new password.
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	if len(password) > maxPasswordLength {
+		return fmt.Errorf("password must be at most %d bytes", maxPasswordLength)
+	}
+	return nil
+}
+
+// validateEmail accepts a bare address, without a display name.
+func validateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || len(email) > 254 {
+		return fmt.Errorf("invalid email address")
+	}
+	return nil
+}
+
+func sendVerificationEmail(ctx context.Context, user *models.User) error {
+	token, err := auth.IssueUserToken(user.ID, models.TokenPurposeEmailVerification, auth.EmailVerificationTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", os.Getenv("BASE_URL"), token)
+	return initializers.Mailer.Send(ctx, mailer.VerificationEmail(user.Email, link))
+}
+
+func sendPasswordResetEmail(ctx context.Context, user *models.User) error {
+	token, err := auth.IssueUserToken(user.ID, models.TokenPurposePasswordReset, auth.PasswordResetTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", os.Getenv("BASE_URL"), token)
+	return initializers.Mailer.Send(ctx, mailer.PasswordResetEmail(user.Email, link))
+}
+
+// registerLoginFailure counts a failed login and, when it triggers a lockout
+// on an existing account, records it for the account owner.
+func registerLoginFailure(ctx context.Context, throttleKey string, userID *uuid.UUID) {
+	lockout, failures, err := auth.RegisterFailure(throttleKey)
+	if err != nil {
+		log.Printf("Failed to record login attempt: %v", err)
+		return
+	}
+	if lockout == 0 || userID == nil {
+		return
+	}
+
+	event := models.LockoutEvent{
+		UserID:      *userID,
+		Kind:        models.LockoutKindLogin,
+		Failures:    failures,
+		LockedUntil: time.Now().Add(lockout),
+	}
+	if gc, ok := ctx.Value("GinContextKey").(*gin.Context); ok {
+		event.IPAddress = gc.ClientIP()
+	}
+	if err := auth.RecordLockout(ctx, event); err != nil {
+		log.Printf("Failed to record lockout for user %s: %v", userID, err)
+	}
+}
+
+// pageLimit clamps an optional GraphQL limit argument.
+func pageLimit(limit *int32, def, max int) int {
+	if limit == nil || *limit <= 0 {
+		return def
+	}
+	if int(*limit) > max {
+		return max
+	}
+	return int(*limit)
+}
+
+// pageOffset clamps an optional GraphQL offset argument.
+func pageOffset(offset *int32) int {
+	if offset == nil || *offset < 0 {
+		return 0
+	}
+	return int(*offset)
+}
+
+// checkLoginRateLimit applies the login budget, per client IP, to the
+// credential mutations that share the /graphql route with everything else.
+func checkLoginRateLimit(ctx context.Context) error {
+	gc, ok := ctx.Value("GinContextKey").(*gin.Context)
+	if !ok {
+		return nil
+	}
+
+	policy := ratelimit.PolicyFor(ratelimit.PolicyLogin)
+	res, err := initializers.RateLimiter.Allow(ctx, policy.Name+":ip:"+gc.ClientIP(), policy.Anonymous)
+	if err != nil {
+		log.Printf("Rate limiter error: %v", err)
+		return nil
+	}
+
+	ratelimit.WriteHeaders(gc.Writer.Header(), res)
+	if !res.Allowed {
+		return fmt.Errorf("too many attempts, try again in %s", res.RetryAfter.Round(time.Second))
+	}
+	return nil
+}
+
+func notificationToModel(n *models.Notification) *model.Notification {
+	var readAt *string
+	if n.ReadAt != nil {
+		s := n.ReadAt.String()
+		readAt = &s
+	}
+	return &model.Notification{
+		ID:        n.ID.String(),
+		Type:      n.Type,
+		Title:     n.Title,
+		Body:      n.Body,
+		Data:      n.Data,
+		Read:      n.ReadAt != nil,
+		ReadAt:    readAt,
+		CreatedAt: n.CreatedAt.String(),
+	}
+}
+
+func dataExportToModel(exp *models.DataExport) *model.DataExport {
+	result := &model.DataExport{
+		ID:        exp.ID.String(),
+		Status:    exp.Status,
+		CreatedAt: exp.CreatedAt.String(),
+	}
+	if exp.CompletedAt != nil {
+		completed := exp.CompletedAt.String()
+		result.CompletedAt = &completed
+	}
+	if exp.ExpiresAt != nil {
+		expires := exp.ExpiresAt.String()
+		result.ExpiresAt = &expires
+	}
+	if exp.Error != "" && exp.Status == models.DataExportFailed {
+		result.Error = &exp.Error
+	}
+
+	if exp.Status == models.DataExportReady && exp.FileID != nil && exp.ExpiresAt != nil {
+		if time.Now().After(*exp.ExpiresAt) {
+			result.Status = "expired"
+		} else if link, err := jobs.DataExportLink(*exp.FileID, *exp.ExpiresAt); err == nil {
+			result.DownloadURL = &link
+		}
+	}
+	return result
+}
@@ -0,0 +1,138 @@
+package resolvers
+
+// This file will be automatically regenerated based on the schema, any resolver implementations
+// will be copied through when generating and any unknown code will be moved to the end.
+// Code generated by github.com/99designs/gqlgen version v0.17.73
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/basit/fileshare-backend/graph"
+	"github.com/basit/fileshare-backend/graph/model"
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+	"github.com/basit/fileshare-backend/notifications"
+)
+
+// MarkNotificationRead is the resolver for the markNotificationRead field.
+func (r *mutationResolver) MarkNotificationRead(ctx context.Context, id string) (*model.Notification, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var notification models.Notification
+	if err := initializers.DB.First(&notification, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		return nil, fmt.Errorf("notification not found")
+	}
+
+	if notification.ReadAt == nil {
+		now := time.Now()
+		if err := initializers.DB.Model(&notification).Update("read_at", now).Error; err != nil {
+			return nil, fmt.Errorf("failed to mark notification as read")
+		}
+		notification.ReadAt = &now
+	}
+
+	return notificationToModel(&notification), nil
+}
+
+// MarkAllNotificationsRead is the resolver for the markAllNotificationsRead field.
+func (r *mutationResolver) MarkAllNotificationsRead(ctx context.Context) (int32, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	result := initializers.DB.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to mark notifications as read")
+	}
+
+	return int32(result.RowsAffected), nil
+}
+
+// Notifications is the resolver for the notifications field.
+func (r *queryResolver) Notifications(ctx context.Context, unreadOnly *bool, limit *int32, offset *int32) ([]*model.Notification, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := initializers.DB.Where("user_id = ?", userID)
+	if unreadOnly != nil && *unreadOnly {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var rows []models.Notification
+	if err := query.
+		Order("created_at DESC").
+		Limit(pageLimit(limit, 50, 200)).
+		Offset(pageOffset(offset)).
+		Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch notifications")
+	}
+
+	result := make([]*model.Notification, 0, len(rows))
+	for i := range rows {
+		result = append(result, notificationToModel(&rows[i]))
+	}
+
+	return result, nil
+}
+
+// UnreadNotificationCount is the resolver for the unreadNotificationCount field.
+func (r *queryResolver) UnreadNotificationCount(ctx context.Context) (int32, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	if err := initializers.DB.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count notifications")
+	}
+
+	return int32(count), nil
+}
+
+// NotificationAdded is the resolver for the notificationAdded field.
+func (r *subscriptionResolver) NotificationAdded(ctx context.Context) (<-chan *model.Notification, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	incoming, cancel := notifications.Subscribe(*userID)
+	out := make(chan *model.Notification, 1)
+
+	go func() {
+		defer close(out)
+		defer cancel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-incoming:
+				select {
+				case out <- notificationToModel(n):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// Subscription returns graph.SubscriptionResolver implementation.
+func (r *Resolver) Subscription() graph.SubscriptionResolver { return &subscriptionResolver{r} }
+
+type subscriptionResolver struct{ *Resolver }
@@ -17,6 +17,7 @@ type Resolver struct {
 type contextKey string
 
 const UserIDKey contextKey = "userID"
+const UserRoleKey contextKey = "userRole"
 
 // Helper functions for auth context
 func GetUserIDFromContext(ctx context.Context) (*uuid.UUID, error) {
@@ -32,3 +33,8 @@ func GetUserIDFromContext(ctx context.Context) (*uuid.UUID, error) {
 
 	return &userID, nil
 }
+
+func GetUserRoleFromContext(ctx context.Context) string {
+	role, _ := ctx.Value(UserRoleKey).(string)
+	return role
+}
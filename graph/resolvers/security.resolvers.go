@@ -0,0 +1,58 @@
+package resolvers
+
+// This file will be automatically regenerated based on the schema, any resolver implementations
+// will be copied through when generating and any unknown code will be moved to the end.
+// Code generated by github.com/99designs/gqlgen version v0.17.73
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/basit/fileshare-backend/graph/model"
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+)
+
+// LockoutEvents is the resolver for the lockoutEvents field.
+func (r *queryResolver) LockoutEvents(ctx context.Context, limit *int32) ([]*model.LockoutEvent, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []struct {
+		models.LockoutEvent
+		FileName *string
+	}
+	if err := initializers.DB.
+		Table("lockout_events").
+		Select("lockout_events.*, files.original_name AS file_name").
+		Joins("LEFT JOIN files ON files.id = lockout_events.file_id").
+		Where("lockout_events.user_id = ?", userID).
+		Order("lockout_events.created_at DESC").
+		Limit(pageLimit(limit, 50, 200)).
+		Scan(&events).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch lockout events")
+	}
+
+	result := make([]*model.LockoutEvent, 0, len(events))
+	for _, event := range events {
+		var fileID *string
+		if event.FileID != nil {
+			id := event.FileID.String()
+			fileID = &id
+		}
+		result = append(result, &model.LockoutEvent{
+			ID:          event.ID.String(),
+			Kind:        event.Kind,
+			FileID:      fileID,
+			FileName:    event.FileName,
+			IPAddress:   event.IPAddress,
+			Failures:    int32(event.Failures),
+			LockedUntil: event.LockedUntil.String(),
+			CreatedAt:   event.CreatedAt.String(),
+		})
+	}
+
+	return result, nil
+}
@@ -8,14 +8,18 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/url"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"golang.org/x/crypto/bcrypt"
-
+	"github.com/basit/fileshare-backend/analytics"
+	"github.com/basit/fileshare-backend/audit"
+	"github.com/basit/fileshare-backend/auth"
 	"github.com/basit/fileshare-backend/graph/model"
 	"github.com/basit/fileshare-backend/initializers"
 	"github.com/basit/fileshare-backend/models"
+	"github.com/basit/fileshare-backend/throttle"
+	"github.com/gin-gonic/gin"
+	"github.com/markbates/goth"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // ChangePassword is the resolver for the changePassword field.
@@ -35,6 +39,10 @@ func (r *mutationResolver) ChangePassword(ctx context.Context, currentPassword s
 		return false, fmt.Errorf("incorrect current password")
 	}
 
+	if err := validatePassword(newPassword); err != nil {
+		return false, err
+	}
+
 	newHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
 	if err != nil {
 		return false, fmt.Errorf("failed to hash new password")
@@ -45,6 +53,13 @@ func (r *mutationResolver) ChangePassword(ctx context.Context, currentPassword s
 		return false, fmt.Errorf("failed to update password")
 	}
 
+	audit.RecordFromContext(ctx, audit.Event{
+		ActorID:    userID,
+		Action:     audit.ActionPasswordChange,
+		TargetType: audit.TargetUser,
+		TargetID:   userID.String(),
+	})
+
 	return true, nil
 }
 
@@ -68,11 +83,55 @@ func (r *mutationResolver) UpdateNotificationPreferences(ctx context.Context, do
 	}
 
 	return &model.User{
-		ID:              user.ID.String(),
-		Email:           user.Email,
-		CreatedAt:       user.CreatedAt.String(),
-		DownloadAlerts:  user.DownloadAlerts,
-		ExpiryReminders: user.ExpiryReminders,
+		ID:                   user.ID.String(),
+		Email:                user.Email,
+		CreatedAt:            user.CreatedAt.String(),
+		DownloadAlerts:       user.DownloadAlerts,
+		ExpiryReminders:      user.ExpiryReminders,
+		DetailedDownloadLogs: user.DetailedDownloadLogs,
+		EmailVerified:        user.EmailVerified,
+		Role:                 roleToModel(user.Role),
+	}, nil
+}
+
+// UpdatePrivacyPreferences is the resolver for the updatePrivacyPreferences field.
+func (r *mutationResolver) UpdatePrivacyPreferences(ctx context.Context, detailedDownloadLogs bool) (*model.User, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := initializers.DB.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	// Only new events are affected; the anonymise job strips older ones
+	// on its usual schedule.
+	if err := initializers.DB.Model(&models.User{}).
+		Where("id = ?", userID).
+		Update("detailed_download_logs", detailedDownloadLogs).Error; err != nil {
+		return nil, fmt.Errorf("failed to update preferences")
+	}
+	user.DetailedDownloadLogs = detailedDownloadLogs
+
+	audit.RecordFromContext(ctx, audit.Event{
+		ActorID:    userID,
+		Action:     audit.ActionPrivacyUpdate,
+		TargetType: audit.TargetUser,
+		TargetID:   userID.String(),
+		Metadata:   map[string]interface{}{"detailedDownloadLogs": detailedDownloadLogs},
+	})
+
+	return &model.User{
+		ID:                   user.ID.String(),
+		Email:                user.Email,
+		CreatedAt:            user.CreatedAt.String(),
+		DownloadAlerts:       user.DownloadAlerts,
+		ExpiryReminders:      user.ExpiryReminders,
+		DetailedDownloadLogs: user.DetailedDownloadLogs,
+		EmailVerified:        user.EmailVerified,
+		Role:                 roleToModel(user.Role),
 	}, nil
 }
 
@@ -93,9 +152,8 @@ func (r *mutationResolver) DeleteAccount(ctx context.Context) (bool, error) {
 	// Try to delete from S3, but don't fail account deletion if this fails
 	s3DeleteErr := deleteUserFilesFromS3(userID.String())
 	if s3DeleteErr != nil {
+		// The storage reconciliation job picks up whatever was left behind.
 		log.Printf("S3 cleanup failed for user %s: %v", userID.String(), s3DeleteErr)
-		// You could queue this for retry later
-		// queueS3Cleanup(userID.String())
 	}
 
 	// Step 1: Get user's files first
@@ -113,7 +171,7 @@ func (r *mutationResolver) DeleteAccount(ctx context.Context) (bool, error) {
 
 	// Step 3: Delete download events for those file IDs
 	if len(fileIDs) > 0 {
-		if err := tx.Where("file_id IN ?", fileIDs).Delete(&models.DownloadEvent{}).Error; err != nil {
+		if err := analytics.DeleteFileData(tx, fileIDs); err != nil {
 			tx.Rollback()
 			return false, fmt.Errorf("failed to delete download events: %w", err)
 		}
@@ -125,6 +183,52 @@ func (r *mutationResolver) DeleteAccount(ctx context.Context) (bool, error) {
 		return false, fmt.Errorf("failed to delete files: %w", err)
 	}
 
+	if err := tx.Where("user_id = ?", userID).Delete(&models.UserIdentity{}).Error; err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("failed to delete linked accounts: %w", err)
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&models.UserToken{}).Error; err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("failed to delete user tokens: %w", err)
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&models.LockoutEvent{}).Error; err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("failed to delete lockout events: %w", err)
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&models.EgressUsage{}).Error; err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("failed to delete transfer usage: %w", err)
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&models.Notification{}).Error; err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("failed to delete notifications: %w", err)
+	}
+
+	if err := tx.Where("owner_id = ?", userID).Delete(&models.PendingDownloadAlert{}).Error; err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("failed to delete pending download alerts: %w", err)
+	}
+
+	if err := tx.Where("webhook_id IN (?)", tx.Model(&models.Webhook{}).Select("id").Where("user_id = ?", userID)).
+		Delete(&models.WebhookDelivery{}).Error; err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("failed to delete webhook deliveries: %w", err)
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&models.Webhook{}).Error; err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("failed to delete webhooks: %w", err)
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&models.DataExport{}).Error; err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("failed to delete data exports: %w", err)
+	}
+
 	if err := tx.Delete(&models.User{}, "id = ?", userID).Error; err != nil {
 		tx.Rollback()
 		return false, fmt.Errorf("failed to delete account: %w", err)
@@ -134,6 +238,14 @@ func (r *mutationResolver) DeleteAccount(ctx context.Context) (bool, error) {
 		return false, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	audit.RecordFromContext(ctx, audit.Event{
+		ActorID:    userID,
+		Action:     audit.ActionAccountDelete,
+		TargetType: audit.TargetUser,
+		TargetID:   userID.String(),
+		Metadata:   map[string]interface{}{"files": len(fileIDs), "s3CleanupFailed": s3DeleteErr != nil},
+	})
+
 	// If S3 cleanup failed, you might want to return a warning
 	if s3DeleteErr != nil {
 		log.Printf("Account deleted but S3 cleanup incomplete for user %s", userID.String())
@@ -142,6 +254,86 @@ func (r *mutationResolver) DeleteAccount(ctx context.Context) (bool, error) {
 	return true, nil
 }
 
+// StartIdentityLink is the resolver for the startIdentityLink field.
+func (r *mutationResolver) StartIdentityLink(ctx context.Context, provider string) (string, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := goth.GetProvider(provider); err != nil {
+		return "", fmt.Errorf("unknown provider: %s", provider)
+	}
+
+	token, err := auth.IssueUserToken(*userID, models.TokenPurposeIdentityLink, auth.IdentityLinkTokenTTL)
+	if err != nil {
+		return "", err
+	}
+
+	// The token travels in a cookie bound to this browser rather than in the
+	// URL, so it cannot be handed to someone else.
+	gc, ok := ctx.Value("GinContextKey").(*gin.Context)
+	if !ok {
+		return "", fmt.Errorf("could not start link")
+	}
+	auth.SetIdentityLinkCookie(gc.Writer, token)
+
+	// The frontend sends the browser to this path on the API host.
+	return fmt.Sprintf("/auth/%s?link=1", url.PathEscape(provider)), nil
+}
+
+// UnlinkIdentity is the resolver for the unlinkIdentity field.
+func (r *mutationResolver) UnlinkIdentity(ctx context.Context, provider string) (bool, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var user models.User
+	if err := initializers.DB.First(&user, "id = ?", userID).Error; err != nil {
+		return false, fmt.Errorf("user not found")
+	}
+
+	var identities []models.UserIdentity
+	if err := initializers.DB.Where("user_id = ?", userID).Find(&identities).Error; err != nil {
+		return false, fmt.Errorf("failed to fetch linked accounts")
+	}
+
+	remaining := 0
+	found := false
+	for _, identity := range identities {
+		if identity.Provider == provider {
+			found = true
+		} else {
+			remaining++
+		}
+	}
+	if !found {
+		return false, fmt.Errorf("%s is not linked to your account", provider)
+	}
+
+	// Keep at least one way to sign in.
+	if remaining == 0 && user.PasswordHash == "" {
+		return false, fmt.Errorf("cannot unlink your only login method, set a password first")
+	}
+
+	if err := initializers.DB.
+		Where("user_id = ? AND provider = ?", userID, provider).
+		Delete(&models.UserIdentity{}).Error; err != nil {
+		return false, fmt.Errorf("failed to unlink account")
+	}
+
+	audit.RecordFromContext(ctx, audit.Event{
+		ActorID:    userID,
+		Action:     audit.ActionIdentityUnlink,
+		TargetType: audit.TargetUser,
+		TargetID:   userID.String(),
+		Metadata:   map[string]interface{}{"provider": provider},
+	})
+
+	return true, nil
+}
+
 // Me is the resolver for the me field.
 func (r *queryResolver) Me(ctx context.Context) (*model.User, error) {
 	userID, err := GetUserIDFromContext(ctx)
@@ -155,11 +347,14 @@ func (r *queryResolver) Me(ctx context.Context) (*model.User, error) {
 	}
 
 	return &model.User{
-		ID:              user.ID.String(),
-		Email:           user.Email,
-		CreatedAt:       user.CreatedAt.String(),
-		DownloadAlerts:  user.DownloadAlerts,
-		ExpiryReminders: user.ExpiryReminders,
+		ID:                   user.ID.String(),
+		Email:                user.Email,
+		CreatedAt:            user.CreatedAt.String(),
+		DownloadAlerts:       user.DownloadAlerts,
+		ExpiryReminders:      user.ExpiryReminders,
+		DetailedDownloadLogs: user.DetailedDownloadLogs,
+		EmailVerified:        user.EmailVerified,
+		Role:                 roleToModel(user.Role),
 	}, nil
 }
 
@@ -176,109 +371,95 @@ func (r *queryResolver) UserStats(ctx context.Context) (*model.UserStats, error)
 
 	// Total Files
 	if err := initializers.DB.Model(&models.File{}).
+		Scopes(models.Uploads).
 		Where("user_id = ?", userID).
 		Count(&totalFiles).Error; err != nil {
 		return nil, err
 	}
 
-	// Total Downloads
+	// Total Downloads, from the daily rollups of finished days and the raw
+	// events of the days the rollup hasn't finished
+	finalBefore, err := analytics.FinalBefore()
+	if err != nil {
+		return nil, err
+	}
 	err = initializers.DB.
+		Model(&models.FileDailyStat{}).
+		Joins("JOIN files ON files.id = file_daily_stats.file_id").
+		Scopes(models.Uploads).
+		Where("files.user_id = ? AND file_daily_stats.day < ?", userID, finalBefore.Format("2006-01-02")).
+		Select("COALESCE(SUM(file_daily_stats.downloads), 0)").
+		Scan(&totalDownloads).Error
+	if err != nil {
+		return nil, err
+	}
+	var recentDownloads int64
+	if err := initializers.DB.
 		Model(&models.DownloadEvent{}).
 		Joins("JOIN files ON files.id = download_events.file_id").
-		Where("files.user_id = ?", userID).
-		Count(&totalDownloads).Error
-	if err != nil {
+		Scopes(models.Uploads).
+		Where("files.user_id = ? AND download_events.created_at >= ?", userID, finalBefore).
+		Count(&recentDownloads).Error; err != nil {
 		return nil, err
 	}
+	totalDownloads += recentDownloads
 
 	// Total Storage Used (in bytes)
 	err = initializers.DB.
 		Model(&models.File{}).
+		Scopes(models.Uploads).
 		Where("user_id = ?", userID).
 		Select("COALESCE(SUM(file_size), 0)").Scan(&totalSizeBytes).Error
 	if err != nil {
 		return nil, err
 	}
 
-	return &model.UserStats{
-		TotalFiles:     int32(totalFiles),
-		TotalDownloads: int32(totalDownloads),
-		StorageUsed:    formatSize(totalSizeBytes),
-	}, nil
-}
-
-func deleteUserFilesFromS3(userID string) error {
-	var files []models.File
-	if err := initializers.DB.Where("user_id = ?", userID).Find(&files).Error; err != nil {
-		return fmt.Errorf("failed to fetch user files: %w", err)
+	egress, err := throttle.MonthlyEgress(initializers.DB, *userID)
+	if err != nil {
+		return nil, err
 	}
 
-	s3Client := initializers.S3Client
-	var deleteErrors []error
-
-	for _, file := range files {
-		_, err := s3Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-			Bucket: aws.String(initializers.S3Bucket),
-			Key:    aws.String(file.StoragePath), // This is now the S3 key after your fix
-		})
-		if err != nil {
-			log.Printf("Failed to delete S3 object %s: %v", file.StoragePath, err)
-			deleteErrors = append(deleteErrors, err)
-		}
+	stats := &model.UserStats{
+		TotalFiles:      int32(totalFiles),
+		TotalDownloads:  int32(totalDownloads),
+		StorageUsed:     formatSize(totalSizeBytes),
+		EgressThisMonth: formatSize(egress),
 	}
-
-	if len(deleteErrors) > 0 {
-		return fmt.Errorf("failed to delete %d files from S3", len(deleteErrors))
+	if throttle.MonthlyEgressBudget > 0 {
+		budget := formatSize(throttle.MonthlyEgressBudget)
+		stats.EgressBudget = &budget
 	}
 
-	return nil
+	return stats, nil
 }
 
-func formatSize(bytes int64) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
-	}
-	div, exp := int64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
+// LinkedIdentities is the resolver for the linkedIdentities field.
+func (r *queryResolver) LinkedIdentities(ctx context.Context) ([]*model.LinkedIdentity, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
-}
 
-
-// !!! WARNING !!!
-// The code below was going to be deleted when updating resolvers. It has been copied here so you have
-// one last chance to move it out of harms way if you want. There are two reasons this happens:
-//  - When renaming or deleting a resolver the old code will be put in here. You can safely delete
-//    it when you're done.
-//  - You have helper methods in this file. Move them out to keep these resolver files clean.
-/*
-	func deleteUserFilesFromS3(userID string) error {
-	var files []models.File
-	if err := initializers.DB.Where("user_id = ?", userID).Find(&files).Error; err != nil {
-		return fmt.Errorf("failed to fetch user files: %w", err)
+	var identities []models.UserIdentity
+	if err := initializers.DB.
+		Where("user_id = ?", userID).
+		Order("created_at").
+		Find(&identities).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch linked accounts")
 	}
 
-	s3Client := initializers.S3Client
-	var deleteErrors []error
-
-	for _, file := range files {
-		_, err := s3Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-			Bucket: aws.String(initializers.S3Bucket),
-			Key:    aws.String(file.StoragePath), // This is now the S3 key after your fix
-		})
-		if err != nil {
-			log.Printf("Failed to delete S3 object %s: %v", file.StoragePath, err)
-			deleteErrors = append(deleteErrors, err)
+	result := make([]*model.LinkedIdentity, 0, len(identities))
+	for _, identity := range identities {
+		var email *string
+		if identity.Email != "" {
+			email = &identity.Email
 		}
+		result = append(result, &model.LinkedIdentity{
+			Provider: identity.Provider,
+			Email:    email,
+			LinkedAt: identity.CreatedAt.String(),
+		})
 	}
 
-	if len(deleteErrors) > 0 {
-		return fmt.Errorf("failed to delete %d files from S3", len(deleteErrors))
-	}
-
-	return nil
+	return result, nil
 }
-*/
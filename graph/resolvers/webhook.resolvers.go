@@ -0,0 +1,261 @@
+package resolvers
+
+// This file will be automatically regenerated based on the schema, any resolver implementations
+// will be copied through when generating and any unknown code will be moved to the end.
+// Code generated by github.com/99designs/gqlgen version v0.17.73
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/basit/fileshare-backend/graph/model"
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+	"github.com/basit/fileshare-backend/webhooks"
+	"gorm.io/gorm"
+)
+
+// CreateWebhook is the resolver for the createWebhook field.
+func (r *mutationResolver) CreateWebhook(ctx context.Context, url string, events []string) (*model.WebhookWithSecret, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := webhooks.ValidateURL(url); err != nil {
+		return nil, err
+	}
+	events, err = validateWebhookEvents(events)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := initializers.DB.Model(&models.Webhook{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("failed to count webhooks")
+	}
+	if count >= maxWebhooksPerUser {
+		return nil, fmt.Errorf("you can register at most %d webhooks", maxWebhooksPerUser)
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	hook := models.Webhook{
+		UserID: *userID,
+		URL:    url,
+		Events: events,
+		Active: true,
+		Secret: secret,
+	}
+	if err := initializers.DB.Create(&hook).Error; err != nil {
+		return nil, fmt.Errorf("failed to create webhook")
+	}
+
+	return &model.WebhookWithSecret{Webhook: webhookToModel(&hook), Secret: secret}, nil
+}
+
+// UpdateWebhook is the resolver for the updateWebhook field.
+func (r *mutationResolver) UpdateWebhook(ctx context.Context, id string, url *string, events []string, active *bool) (*model.Webhook, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hook, err := loadOwnWebhook(userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if url != nil {
+		if err := webhooks.ValidateURL(*url); err != nil {
+			return nil, err
+		}
+		hook.URL = *url
+	}
+	if events != nil {
+		if hook.Events, err = validateWebhookEvents(events); err != nil {
+			return nil, err
+		}
+	}
+	if active != nil {
+		hook.Active = *active
+	}
+
+	// Saved as a struct so the secret goes back through its serializer.
+	if err := initializers.DB.Save(hook).Error; err != nil {
+		return nil, fmt.Errorf("failed to update webhook")
+	}
+
+	return webhookToModel(hook), nil
+}
+
+// DeleteWebhook is the resolver for the deleteWebhook field.
+func (r *mutationResolver) DeleteWebhook(ctx context.Context, id string) (bool, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	hook, err := loadOwnWebhook(userID, id)
+	if err != nil {
+		return false, err
+	}
+
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", hook.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(hook).Error
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete webhook")
+	}
+
+	return true, nil
+}
+
+// RotateWebhookSecret is the resolver for the rotateWebhookSecret field.
+func (r *mutationResolver) RotateWebhookSecret(ctx context.Context, id string) (*model.WebhookWithSecret, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hook, err := loadOwnWebhook(userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	hook.Secret = secret
+	if err := initializers.DB.Save(hook).Error; err != nil {
+		return nil, fmt.Errorf("failed to rotate webhook secret")
+	}
+
+	return &model.WebhookWithSecret{Webhook: webhookToModel(hook), Secret: secret}, nil
+}
+
+// SendTestWebhook is the resolver for the sendTestWebhook field.
+func (r *mutationResolver) SendTestWebhook(ctx context.Context, id string) (*model.WebhookDelivery, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hook, err := loadOwnWebhook(userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery, err := webhooks.Enqueue(hook, webhooks.EventTest, map[string]interface{}{
+		"webhookId": hook.ID.String(),
+		"message":   "This is a test event from FileShare.",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return webhookDeliveryToModel(delivery), nil
+}
+
+// RetryWebhookDelivery is the resolver for the retryWebhookDelivery field.
+func (r *mutationResolver) RetryWebhookDelivery(ctx context.Context, id string) (*model.WebhookDelivery, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var delivery models.WebhookDelivery
+	if err := initializers.DB.
+		Joins("JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id").
+		Where("webhook_deliveries.id = ? AND webhooks.user_id = ?", id, userID).
+		First(&delivery).Error; err != nil {
+		return nil, fmt.Errorf("delivery not found")
+	}
+	if delivery.Status != models.WebhookDeliveryFailed {
+		return nil, fmt.Errorf("only failed deliveries can be retried")
+	}
+
+	// Attempts restart so the retry gets the full backoff schedule.
+	delivery.Status = models.WebhookDeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now()
+	if err := initializers.DB.Model(&delivery).Updates(map[string]interface{}{
+		"status":          delivery.Status,
+		"attempts":        delivery.Attempts,
+		"next_attempt_at": delivery.NextAttemptAt,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("failed to retry delivery")
+	}
+
+	return webhookDeliveryToModel(&delivery), nil
+}
+
+// Webhooks is the resolver for the webhooks field.
+func (r *queryResolver) Webhooks(ctx context.Context) ([]*model.Webhook, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var hooks []models.Webhook
+	if err := initializers.DB.
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&hooks).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch webhooks")
+	}
+
+	result := make([]*model.Webhook, 0, len(hooks))
+	for i := range hooks {
+		result = append(result, webhookToModel(&hooks[i]))
+	}
+
+	return result, nil
+}
+
+// WebhookEvents is the resolver for the webhookEvents field.
+func (r *queryResolver) WebhookEvents(ctx context.Context) ([]string, error) {
+	return webhooks.Events, nil
+}
+
+// WebhookDeliveries is the resolver for the webhookDeliveries field.
+func (r *queryResolver) WebhookDeliveries(ctx context.Context, webhookID string, status *string, limit *int32, offset *int32) ([]*model.WebhookDelivery, error) {
+	userID, err := GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hook, err := loadOwnWebhook(userID, webhookID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := initializers.DB.Where("webhook_id = ?", hook.ID)
+	if status != nil && *status != "" {
+		query = query.Where("status = ?", *status)
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := query.
+		Order("created_at DESC").
+		Limit(pageLimit(limit, 50, 200)).
+		Offset(pageOffset(offset)).
+		Find(&deliveries).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch deliveries")
+	}
+
+	result := make([]*model.WebhookDelivery, 0, len(deliveries))
+	for i := range deliveries {
+		result = append(result, webhookDeliveryToModel(&deliveries[i]))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,81 @@
+package resolvers
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/basit/fileshare-backend/graph/model"
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+	"github.com/basit/fileshare-backend/webhooks"
+)
+
+// maxWebhooksPerUser caps how many endpoints one account can register.
+const maxWebhooksPerUser = 10
+
+func loadOwnWebhook(userID *uuid.UUID, id string) (*models.Webhook, error) {
+	var hook models.Webhook
+	if err := initializers.DB.First(&hook, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		return nil, fmt.Errorf("webhook not found")
+	}
+	return &hook, nil
+}
+
+func validateWebhookEvents(events []string) ([]string, error) {
+	if len(events) == 0 {
+		return nil, fmt.Errorf("choose at least one event")
+	}
+	seen := make(map[string]bool, len(events))
+	unique := make([]string, 0, len(events))
+	for _, e := range events {
+		if !webhooks.ValidEvent(e) {
+			return nil, fmt.Errorf("unknown event %q", e)
+		}
+		if !seen[e] {
+			seen[e] = true
+			unique = append(unique, e)
+		}
+	}
+	return unique, nil
+}
+
+func webhookToModel(hook *models.Webhook) *model.Webhook {
+	return &model.Webhook{
+		ID:        hook.ID.String(),
+		URL:       hook.URL,
+		Events:    hook.Events,
+		Active:    hook.Active,
+		CreatedAt: hook.CreatedAt.String(),
+	}
+}
+
+func webhookDeliveryToModel(d *models.WebhookDelivery) *model.WebhookDelivery {
+	result := &model.WebhookDelivery{
+		ID:        d.ID.String(),
+		WebhookID: d.WebhookID.String(),
+		Event:     d.Event,
+		Status:    d.Status,
+		Attempts:  int32(d.Attempts),
+		CreatedAt: d.CreatedAt.String(),
+	}
+	if d.ResponseStatus != nil {
+		status := int32(*d.ResponseStatus)
+		result.ResponseStatus = &status
+	}
+	if d.ResponseBody != "" {
+		result.ResponseBody = &d.ResponseBody
+	}
+	if d.LastError != "" {
+		result.LastError = &d.LastError
+	}
+	if d.Status == models.WebhookDeliveryPending {
+		next := d.NextAttemptAt.String()
+		result.NextAttemptAt = &next
+	}
+	if d.DeliveredAt != nil {
+		delivered := d.DeliveredAt.String()
+		result.DeliveredAt = &delivered
+	}
+	return result
+}
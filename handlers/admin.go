@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/basit/fileshare-backend/audit"
+	"github.com/basit/fileshare-backend/jobs"
+)
+
+func RunCleanup(c *gin.Context) {
+	if err := jobs.RunCleanupNow(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Cleanup failed"})
+		return
+	}
+
+	userID := c.MustGet("userID").(uuid.UUID)
+	audit.Record(c, audit.Event{
+		ActorID: &userID,
+		Action:  audit.ActionAdminRunCleanup,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// ReconcileStorage compares the bucket with the files table. The mode query
+// parameter is dry-run (default), delete or quarantine.
+func ReconcileStorage(c *gin.Context) {
+	mode, err := jobs.ParseReconcileMode(c.Query("mode"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := jobs.Reconcile(c.Request.Context(), mode)
+	if errors.Is(err, jobs.ErrJobRunning) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Reconciliation is already running"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Reconciliation failed"})
+		return
+	}
+
+	userID := c.MustGet("userID").(uuid.UUID)
+	audit.Record(c, audit.Event{
+		ActorID: &userID,
+		Action:  audit.ActionAdminReconcile,
+		Metadata: map[string]interface{}{
+			"mode":        string(report.Mode),
+			"orphans":     report.OrphanCount,
+			"missing":     report.MissingCount,
+			"deleted":     report.Deleted,
+			"quarantined": report.Quarantined,
+		},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"report": report})
+}
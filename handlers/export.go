@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/basit/fileshare-backend/audit"
+	"github.com/basit/fileshare-backend/auth"
+	"github.com/basit/fileshare-backend/export"
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+)
+
+// ExportFiles streams the caller's file listing. The format query parameter
+// is csv (default) or ndjson.
+func ExportFiles(c *gin.Context) {
+	userID := c.MustGet("userID").(uuid.UUID)
+
+	format, err := export.ParseFormat(c.Query("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	startExport(c, format, "files")
+	if err := export.Files(c.Request.Context(), initializers.DB, userID, c.Writer, format); err != nil {
+		// Headers are already out, so the client just sees a short file.
+		log.Printf("File export for user %s failed: %v", userID, err)
+		return
+	}
+
+	recordExport(c, userID, "files", nil)
+}
+
+// ExportDownloadEvents streams the download history of the caller's files.
+// Optional query parameters: fileId, from and to (RFC 3339, to exclusive)
+// and format.
+func ExportDownloadEvents(c *gin.Context) {
+	userID := c.MustGet("userID").(uuid.UUID)
+
+	format, err := export.ParseFormat(c.Query("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter := export.EventFilter{OwnerID: userID}
+	if value := c.Query("fileId"); value != "" {
+		fileID, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fileId"})
+			return
+		}
+		var count int64
+		initializers.DB.Model(&models.File{}).Scopes(models.Uploads).Where("id = ? AND user_id = ?", fileID, userID).Count(&count)
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		filter.FileID = &fileID
+	}
+	for _, param := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s, expected RFC 3339", param.name)})
+			return
+		}
+		*param.dst = &t
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	startExport(c, format, "downloads")
+	if err := export.DownloadEvents(c.Request.Context(), initializers.DB, filter, c.Writer, format); err != nil {
+		log.Printf("Download history export for user %s failed: %v", userID, err)
+		return
+	}
+
+	metadata := map[string]interface{}{}
+	if filter.FileID != nil {
+		metadata["fileId"] = filter.FileID.String()
+	}
+	if filter.From != nil {
+		metadata["from"] = filter.From.Format(time.RFC3339)
+	}
+	if filter.To != nil {
+		metadata["to"] = filter.To.Format(time.RFC3339)
+	}
+	recordExport(c, userID, "downloads", metadata)
+}
+
+// DownloadDataExport redeems the link from a data export email and
+// redirects to a short-lived URL for the archive. The signed token is the
+// auth, so the link works straight from the email.
+func DownloadDataExport(c *gin.Context) {
+	fileID, err := auth.ParseDataExportToken(c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusGone, gin.H{"error": "This link is invalid or has expired"})
+		return
+	}
+
+	var file models.File
+	if err := initializers.DB.
+		Where("id = ? AND kind = ?", fileID, models.FileKindDataExport).
+		First(&file).Error; err != nil ||
+		(file.ExpiresAt != nil && time.Now().After(*file.ExpiresAt)) {
+		c.JSON(http.StatusGone, gin.H{"error": "This export has expired"})
+		return
+	}
+
+	req, err := s3.NewPresignClient(initializers.S3Client).PresignGetObject(c.Request.Context(), &s3.GetObjectInput{
+		Bucket:                     aws.String(initializers.S3Bucket),
+		Key:                        aws.String(file.StoragePath),
+		ResponseContentDisposition: aws.String(fmt.Sprintf("attachment; filename=%q", file.OriginalName)),
+	}, s3.WithPresignExpires(15*time.Minute))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate download URL"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		ActorID:    file.UserID,
+		Action:     audit.ActionTakeoutFetch,
+		TargetType: audit.TargetFile,
+		TargetID:   file.ID.String(),
+	})
+
+	c.Redirect(http.StatusFound, req.URL)
+}
+
+func startExport(c *gin.Context, format export.Format, name string) {
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().UTC().Format("20060102-150405"), format.Extension())
+	c.Header("Content-Type", format.ContentType())
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+}
+
+func recordExport(c *gin.Context, userID uuid.UUID, kind string, metadata map[string]interface{}) {
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	metadata["kind"] = kind
+	audit.Record(c, audit.Event{
+		ActorID:    &userID,
+		Action:     audit.ActionDataExport,
+		TargetType: audit.TargetUser,
+		TargetID:   userID.String(),
+		Metadata:   metadata,
+	})
+}
@@ -3,13 +3,17 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"html/template"
 	"log"
+	"math"
 	"mime"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -20,23 +24,37 @@ import (
 	"github.com/lithammer/shortuuid/v4"
 	"github.com/skip2/go-qrcode"
 	"golang.org/x/crypto/bcrypt"
-	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
+	"github.com/basit/fileshare-backend/analytics"
+	"github.com/basit/fileshare-backend/audit"
+	"github.com/basit/fileshare-backend/auth"
 	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/integrity"
+	"github.com/basit/fileshare-backend/jobs"
 	"github.com/basit/fileshare-backend/models"
-
+	"github.com/basit/fileshare-backend/notifications"
+	"github.com/basit/fileshare-backend/throttle"
+	"github.com/basit/fileshare-backend/webhooks"
 )
 
+// uploadedObject describes an object written by uploadFileToS3.
+type uploadedObject struct {
+	URL    string
+	SHA256 string
+	ETag   string
+}
+
 // uploadFileToS3 uploads a file to AWS S3
-func uploadFileToS3(file multipart.File, fileHeader *multipart.FileHeader, key string) (string, error) {
+func uploadFileToS3(file multipart.File, fileHeader *multipart.FileHeader, key string) (*uploadedObject, error) {
 	uploader := manager.NewUploader(initializers.S3Client)
 
 	buffer := bytes.NewBuffer(nil)
 	if _, err := buffer.ReadFrom(file); err != nil {
-		return "", err
+		return nil, err
 	}
 
-	_, err := uploader.Upload(context.TODO(), &s3.PutObjectInput{
+	out, err := uploader.Upload(context.TODO(), &s3.PutObjectInput{
 		Bucket:      aws.String(initializers.S3Bucket),
 		Key:         aws.String(key),
 		Body:        bytes.NewReader(buffer.Bytes()),
@@ -46,11 +64,14 @@ func uploadFileToS3(file multipart.File, fileHeader *multipart.FileHeader, key s
 
 	if err != nil {
 		log.Printf("S3 Upload Error: %v\n", err)
-		return "", err
+		return nil, err
 	}
 
-	url := "https://" + initializers.S3Bucket + ".s3." + os.Getenv("AWS_REGION") + ".amazonaws.com/" + key
-	return url, nil
+	return &uploadedObject{
+		URL:    "https://" + initializers.S3Bucket + ".s3." + os.Getenv("AWS_REGION") + ".amazonaws.com/" + key,
+		SHA256: integrity.SHA256(buffer.Bytes()),
+		ETag:   integrity.NormalizeETag(out.ETag),
+	}, nil
 }
 
 func UploadFile(c *gin.Context) {
@@ -58,6 +79,23 @@ func UploadFile(c *gin.Context) {
 
 	baseURL := os.Getenv("BASE_URL")
 
+	var user models.User
+	if err := initializers.DB.First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	if !user.EmailVerified {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Please verify your email address before uploading files"})
+		return
+	}
+
+	// Throttle the request body before the multipart form is parsed so the
+	// limit applies to the client's upload itself.
+	c.Request.Body = throttle.NewReadCloser(c.Request.Context(), c.Request.Body,
+		throttle.NewLimiter(throttle.UploadPerConn),
+		throttle.UploadPool.Get(userID.String()),
+	)
+
 	fileHeader, err := c.FormFile("file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
@@ -71,7 +109,7 @@ func UploadFile(c *gin.Context) {
 	}
 
 	key := uuid.New().String() + "_" + fileHeader.Filename
-	s3URL, err := uploadFileToS3(file, fileHeader, key)
+	uploaded, err := uploadFileToS3(file, fileHeader, key)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload to S3"})
 		return
@@ -116,17 +154,37 @@ func UploadFile(c *gin.Context) {
 		PublicURL:    fmt.Sprintf("%s/d/%s", baseURL, downloadSlug),
 		IsPublic:     true,
 		ContentType:  mimeType,
+
+		ChecksumSHA256:  uploaded.SHA256,
+		StorageETag:     uploaded.ETag,
+		IntegrityStatus: models.IntegrityOK,
 	}
 
 	if err := initializers.DB.Create(&newFile).Error; err != nil {
+		// Don't leave an object behind that no row points to.
+		if _, delErr := initializers.S3Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+			Bucket: aws.String(initializers.S3Bucket),
+			Key:    aws.String(key),
+		}); delErr != nil {
+			log.Printf("Failed to remove S3 object %s after DB error: %v", key, delErr)
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB save failed"})
 		return
 	}
 	initializers.DB.Preload("User").First(&newFile, "id = ?", newFile.ID)
 
+	audit.Record(c, audit.Event{
+		ActorID:    &userID,
+		Action:     audit.ActionFileUpload,
+		TargetType: audit.TargetFile,
+		TargetID:   newFile.ID.String(),
+		Metadata:   map[string]interface{}{"name": newFile.OriginalName, "size": newFile.FileSize},
+	})
+	webhooks.Dispatch(&userID, webhooks.EventFileUploaded, webhooks.FileData(&newFile))
+
 	c.JSON(http.StatusOK, gin.H{
 		"file":   newFile,
-		"s3_url": s3URL,
+		"s3_url": uploaded.URL,
 		"qr_url": fmt.Sprintf("%s/api/files/%s/qr", baseURL, newFile.DownloadSlug),
 	})
 }
@@ -142,6 +200,7 @@ func ListFiles(c *gin.Context) {
 
 	if err := initializers.DB.
 		Preload("User").
+		Scopes(models.Uploads).
 		Where("user_id = ?", userID).
 		Find(&files).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
@@ -169,6 +228,7 @@ func ListFiles(c *gin.Context) {
 
 func RenameFile(c *gin.Context) {
 	id := c.Param("id")
+	userID := c.MustGet("userID").(uuid.UUID)
 	var body struct {
 		NewName string `json:"newName"`
 	}
@@ -177,22 +237,36 @@ func RenameFile(c *gin.Context) {
 		return
 	}
 
-	if err := initializers.DB.Model(&models.File{}).
-		Where("id = ?", id).
+	var file models.File
+	if err := initializers.DB.Scopes(models.Uploads).First(&file, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	if err := initializers.DB.Model(&file).
 		Update("original_name", body.NewName).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Rename failed"})
 		return
 	}
 
+	audit.Record(c, audit.Event{
+		ActorID:    &userID,
+		Action:     audit.ActionFileRename,
+		TargetType: audit.TargetFile,
+		TargetID:   file.ID.String(),
+		Metadata:   map[string]interface{}{"from": file.OriginalName, "to": body.NewName},
+	})
+
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
 func DeleteFile(c *gin.Context) {
 	id := c.Param("id")
+	userID := c.MustGet("userID").(uuid.UUID)
 
 	// Fetch file from DB first to get StoragePath
 	var file models.File
-	if err := initializers.DB.First(&file, "id = ?", id).Error; err != nil {
+	if err := initializers.DB.Scopes(models.Uploads).First(&file, "id = ? AND user_id = ?", id, userID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
@@ -208,7 +282,7 @@ func DeleteFile(c *gin.Context) {
 		return
 	}
 
-	if err := initializers.DB.Where("file_id = ?", file.ID).Delete(&models.DownloadEvent{}).Error; err != nil {
+	if err := analytics.DeleteFileData(initializers.DB, []uuid.UUID{file.ID}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete download events"})
 		return
 	}
@@ -218,9 +292,93 @@ func DeleteFile(c *gin.Context) {
 		return
 	}
 
+	audit.Record(c, audit.Event{
+		ActorID:    &userID,
+		Action:     audit.ActionFileDelete,
+		TargetType: audit.TargetFile,
+		TargetID:   file.ID.String(),
+		Metadata:   map[string]interface{}{"name": file.OriginalName, "owner": file.UserID},
+	})
+	webhooks.Dispatch(file.UserID, webhooks.EventFileDeleted, webhooks.FileData(&file))
+
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
+// UpdateShareSettings changes who can download a file: public or owner-only,
+// an optional download password, and the expiry time.
+func UpdateShareSettings(c *gin.Context) {
+	userID := c.MustGet("userID").(uuid.UUID)
+	id := c.Param("id")
+
+	var body struct {
+		IsPublic       *bool      `json:"isPublic"`
+		Password       *string    `json:"password"`
+		RemovePassword bool       `json:"removePassword"`
+		ExpiresAt      *time.Time `json:"expiresAt"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	var file models.File
+	if err := initializers.DB.Scopes(models.Uploads).First(&file, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	changes := map[string]interface{}{}
+	if body.IsPublic != nil {
+		updates["is_public"] = *body.IsPublic
+		changes["isPublic"] = *body.IsPublic
+	}
+	if body.RemovePassword {
+		updates["password_hash"] = nil
+		changes["password"] = "removed"
+	} else if body.Password != nil && *body.Password != "" {
+		hashBytes, err := bcrypt.GenerateFromPassword([]byte(*body.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+		updates["password_hash"] = string(hashBytes)
+		changes["password"] = "set"
+	}
+	if body.ExpiresAt != nil {
+		if body.ExpiresAt.Before(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+			return
+		}
+		updates["expires_at"] = *body.ExpiresAt
+		changes["expiresAt"] = *body.ExpiresAt
+	}
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	if err := initializers.DB.Model(&file).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update share settings"})
+		return
+	}
+	// Respond with the settings as stored, not as they were loaded.
+	if err := initializers.DB.First(&file, "id = ?", file.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load file"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		ActorID:    &userID,
+		Action:     audit.ActionFileShareUpdate,
+		TargetType: audit.TargetFile,
+		TargetID:   file.ID.String(),
+		Metadata:   changes,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"file": file})
+}
+
 // generateSlug generates a random slug for file downloads.
 func generateSlug() string {
 	return shortuuid.New()
@@ -232,7 +390,7 @@ func DownloadFile(c *gin.Context) {
 
 	var file models.File
 
-	if err := initializers.DB.Where("download_slug = ?", slug).First(&file).Error; err != nil {
+	if err := initializers.DB.Scopes(models.Uploads).Where("download_slug = ?", slug).First(&file).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
@@ -252,37 +410,46 @@ func DownloadFile(c *gin.Context) {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Password required"})
 			return
 		}
+
+		throttleKey := auth.FileThrottleKey(file.DownloadSlug)
+		if err := auth.CheckLockout(throttleKey); err != nil {
+			abortLockedOut(c, err)
+			return
+		}
 		if err := bcrypt.CompareHashAndPassword([]byte(*file.PasswordHash), []byte(body.Password)); err != nil {
+			registerFilePasswordFailure(c, &file, throttleKey)
 			c.JSON(http.StatusForbidden, gin.H{"error": "Incorrect password"})
 			return
 		}
+		if err := auth.ResetFailures(throttleKey); err != nil {
+			log.Printf("Failed to reset password attempts for file %s: %v", file.ID, err)
+		}
 	}
 	if file.ExpiresAt != nil && time.Now().After(*file.ExpiresAt) {
 		c.JSON(http.StatusGone, gin.H{"error": "This file has expired"})
 		return
 	}
+	if file.IntegrityStatus == models.IntegrityMismatch || file.IntegrityStatus == models.IntegrityMissing {
+		c.JSON(http.StatusConflict, gin.H{"error": "This file failed an integrity check and can't be downloaded"})
+		return
+	}
 
-	// Atomic counter update
-	initializers.DB.Model(&file).UpdateColumn("download_count", gorm.Expr("download_count + ?", 1))
-	initializers.DB.Model(&file).Update("last_downloaded_at", time.Now())
+	if file.UserID != nil {
+		exceeded, err := throttle.EgressBudgetExceeded(initializers.DB, *file.UserID)
+		if err != nil {
+			log.Printf("Failed to check egress budget for user %s: %v", *file.UserID, err)
+		} else if exceeded {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "The owner of this file has used up this month's transfer budget"})
+			return
+		}
+	}
 
-	// Log the download event
 	var userID *uuid.UUID
 	if uid, ok := c.Get("userID"); ok {
 		uidVal := uid.(uuid.UUID)
 		userID = &uidVal
 	}
 
-	downloadEvent := models.DownloadEvent{
-		ID:        uuid.New(),
-		FileID:    file.ID,
-		UserID:    userID,
-		IPAddress: c.ClientIP(),
-		UserAgent: c.Request.UserAgent(),
-		CreatedAt: time.Now(),
-	}
-	initializers.DB.Create(&downloadEvent)
-
 	s3URL, err := generatePresignedURL(file.StoragePath)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate S3 URL"})
@@ -296,19 +463,199 @@ func DownloadFile(c *gin.Context) {
 	}
 	defer resp.Body.Close()
 
+	// Only a download that is actually being served counts. The event and
+	// the file's download counters are written in the background, in
+	// batches.
+	analytics.Events.Record(models.DownloadEvent{
+		ID:        uuid.New(),
+		FileID:    file.ID,
+		UserID:    userID,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Referrer:  c.Request.Referer(),
+		CreatedAt: time.Now(),
+	})
+	notifications.EnqueueDownloadAlert(&file, userID)
+	webhooks.Dispatch(file.UserID, webhooks.EventFileDownloaded, webhooks.FileData(&file))
+
 	// ⬇️ Only force download if not previewing
 	if !preview {
 		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.OriginalName))
 	}
 
-	c.DataFromReader(http.StatusOK, resp.ContentLength, resp.Header.Get("Content-Type"), resp.Body, nil)
+	// Signed-in downloaders share one budget; anonymous traffic shares one
+	// per public link.
+	poolKey := "link:" + file.DownloadSlug
+	if userID != nil {
+		poolKey = "user:" + userID.String()
+	}
+	verifier := integrity.NewVerifier(resp.Body)
+	body := throttle.NewReader(c.Request.Context(), verifier,
+		throttle.NewLimiter(throttle.DownloadPerConn),
+		throttle.DownloadPool.Get(poolKey),
+	)
+
+	c.DataFromReader(http.StatusOK, resp.ContentLength, resp.Header.Get("Content-Type"), body, nil)
+
+	// The response has already gone out, so a mismatch can only be flagged
+	// to stop further downloads. Partial reads (client went away) are skipped.
+	if file.ChecksumSHA256 != "" && resp.ContentLength >= 0 && verifier.BytesRead() == resp.ContentLength {
+		if sum := verifier.Sum(); sum != file.ChecksumSHA256 {
+			integrity.Flag(&file, models.IntegrityMismatch, fmt.Sprintf("download hashed to %s, expected %s", sum, file.ChecksumSHA256))
+		}
+	}
+
+	if file.UserID != nil {
+		if err := throttle.RecordEgress(initializers.DB, *file.UserID, body.BytesRead()); err != nil {
+			log.Printf("Failed to record egress for user %s: %v", *file.UserID, err)
+		}
+	}
+}
+
+// extendConfirmPage asks the owner to confirm an extension. Mail scanners
+// and link previews follow links, so opening one changes nothing; the
+// extension is applied when the form is posted back to the same URL.
+var extendConfirmPage = template.Must(template.New("extend").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Keep {{.Name}}</title>
+</head>
+<body>
+<h1>Keep {{.Name}}?</h1>
+<p>This file expires on {{.ExpiresAt}}. Keep it until {{.NewExpiresAt}}?</p>
+<form method="post">
+<button type="submit">Keep file</button>
+</form>
+</body>
+</html>
+`))
+
+// extendedExpiry is the expiry a reminder link moves a file to.
+func extendedExpiry(current time.Time) time.Time {
+	base := current
+	if now := time.Now(); now.After(base) {
+		base = now
+	}
+	return base.Add(jobs.ExpiryExtension)
+}
+
+// ConfirmExtendFileExpiry shows the confirmation page for the link in an
+// expiry reminder.
+func ConfirmExtendFileExpiry(c *gin.Context) {
+	redirectBase := fmt.Sprintf("%s/files", os.Getenv("BASE_URL"))
+
+	fileID, current, err := auth.ParseExtendExpiryToken(c.Param("token"))
+	if err != nil {
+		c.Redirect(http.StatusTemporaryRedirect, redirectBase+"?extend_error=invalid")
+		return
+	}
+
+	var file models.File
+	if err := initializers.DB.Scopes(models.Uploads).
+		Where("id = ? AND FLOOR(EXTRACT(EPOCH FROM expires_at)) = ?", fileID, current.Unix()).
+		First(&file).Error; err != nil {
+		c.Redirect(http.StatusTemporaryRedirect, redirectBase+"?extend_error=used")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := extendConfirmPage.Execute(c.Writer, map[string]string{
+		"Name":         file.OriginalName,
+		"ExpiresAt":    current.UTC().Format(time.RFC1123),
+		"NewExpiresAt": extendedExpiry(current).UTC().Format(time.RFC1123),
+	}); err != nil {
+		log.Printf("Failed to render extend page for file %s: %v", file.ID, err)
+	}
+}
+
+// ExtendFileExpiry applies the extension confirmed on the page served by
+// ConfirmExtendFileExpiry and sends the browser back to the frontend's file
+// list.
+func ExtendFileExpiry(c *gin.Context) {
+	redirectBase := fmt.Sprintf("%s/files", os.Getenv("BASE_URL"))
+
+	fileID, current, err := auth.ParseExtendExpiryToken(c.Param("token"))
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, redirectBase+"?extend_error=invalid")
+		return
+	}
+
+	newExpiry := extendedExpiry(current)
+
+	// Matching on the expiry the link was issued for makes the link single
+	// use: once the file has been extended it no longer matches.
+	var files []models.File
+	result := initializers.DB.Model(&files).
+		Clauses(clause.Returning{}).
+		Scopes(models.Uploads).
+		Where("id = ? AND FLOOR(EXTRACT(EPOCH FROM expires_at)) = ?", fileID, current.Unix()).
+		Update("expires_at", newExpiry)
+	if result.Error != nil {
+		log.Printf("Failed to extend file %s: %v", fileID, result.Error)
+		c.Redirect(http.StatusSeeOther, redirectBase+"?extend_error=failed")
+		return
+	}
+	if len(files) == 0 {
+		c.Redirect(http.StatusSeeOther, redirectBase+"?extend_error=used")
+		return
+	}
+
+	file := files[0]
+	audit.Record(c, audit.Event{
+		ActorID:    file.UserID,
+		Action:     audit.ActionFileExtend,
+		TargetType: audit.TargetFile,
+		TargetID:   file.ID.String(),
+		Metadata:   map[string]interface{}{"expiresAt": newExpiry, "via": "reminder"},
+	})
+
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("%s?extended=%s", redirectBase, file.ID))
+}
+
+// registerFilePasswordFailure counts a wrong file password and records a
+// lockout event for the file owner when the file becomes locked.
+func registerFilePasswordFailure(c *gin.Context, file *models.File, throttleKey string) {
+	lockout, failures, err := auth.RegisterFailure(throttleKey)
+	if err != nil {
+		log.Printf("Failed to record password attempt for file %s: %v", file.ID, err)
+		return
+	}
+	if lockout == 0 || file.UserID == nil {
+		return
+	}
+
+	event := models.LockoutEvent{
+		UserID:      *file.UserID,
+		Kind:        models.LockoutKindFilePassword,
+		FileID:      &file.ID,
+		IPAddress:   c.ClientIP(),
+		Failures:    failures,
+		LockedUntil: time.Now().Add(lockout),
+	}
+	if err := auth.RecordLockout(c.Request.Context(), event); err != nil {
+		log.Printf("Failed to record lockout for file %s: %v", file.ID, err)
+	}
+}
+
+func abortLockedOut(c *gin.Context, err error) {
+	var locked *auth.ErrLockedOut
+	if errors.As(err, &locked) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": locked.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check password attempts"})
 }
 
 func HandlePublicDownload(c *gin.Context) {
 	slug := c.Param("slug")
 
 	var file models.File
-	if err := initializers.DB.First(&file, "download_slug = ?", slug).Error; err != nil {
+	if err := initializers.DB.Scopes(models.Uploads).First(&file, "download_slug = ?", slug).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
@@ -343,7 +690,7 @@ func GetQRCode(c *gin.Context) {
 
 	// Optional: Validate if the file exists
 	var file models.File
-	if err := initializers.DB.Where("download_slug = ?", slug).First(&file).Error; err != nil {
+	if err := initializers.DB.Scopes(models.Uploads).Where("download_slug = ?", slug).First(&file).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
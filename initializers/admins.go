@@ -0,0 +1,36 @@
+package initializers
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/basit/fileshare-backend/models"
+)
+
+// promoteConfiguredAdmins grants the admin role to every account listed in
+// ADMIN_EMAILS (comma separated) whose email has been verified, so nobody
+// can claim the role by registering a listed address. It never demotes
+// anyone, so admins can also be managed through the admin API.
+func promoteConfiguredAdmins() {
+	var emails []string
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	if len(emails) == 0 {
+		return
+	}
+
+	result := DB.Model(&models.User{}).
+		Where("email IN ? AND email_verified = ? AND role <> ?", emails, true, models.RoleAdmin).
+		Update("role", models.RoleAdmin)
+	if result.Error != nil {
+		log.Printf("⚠️  Failed to promote ADMIN_EMAILS: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Promoted %d accounts from ADMIN_EMAILS to admin", result.RowsAffected)
+	}
+}
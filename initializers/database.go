@@ -8,6 +8,7 @@ import (
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 
+	"github.com/basit/fileshare-backend/encryption"
 	"github.com/basit/fileshare-backend/models"
 
 )
@@ -24,6 +25,10 @@ func ConnectToDatabase() {
 	if dsn == "" {
 		log.Fatal("❌ DB_URL is not set in environment variables")
 	}
+	if err := encryption.LoadKeysFromEnv(); err != nil {
+		log.Fatalf("❌ TOKEN_ENCRYPTION_KEYS: %v", err)
+	}
+
 	var err error
 
 	DB, err = gorm.Open(postgres.New(postgres.Config{
@@ -48,13 +53,55 @@ func ConnectToDatabase() {
 	// sqlDB.SetMaxIdleConns(2)                   // Set the maximum number of idle connections
 	// sqlDB.SetConnMaxLifetime(30 * time.Minute) // Set the maximum lifetime of a connection
 
+	// Accounts that existed before email verification was introduced are
+	// treated as verified so they keep upload access.
+	backfillEmailVerified := !DB.Migrator().HasColumn(&models.User{}, "EmailVerified")
+
 	// DB.AutoMigrate(&models.Activity{})
 	if err := DB.AutoMigrate(
 		&models.User{},
 		&models.File{},
 		&models.DownloadEvent{},
+		&models.UserToken{},
+		&models.UserIdentity{},
+		&models.AuthThrottle{},
+		&models.LockoutEvent{},
+		&models.AuditEvent{},
+		&models.EgressUsage{},
+		&models.Notification{},
+		&models.PendingDownloadAlert{},
+		&models.ExpiryReminder{},
+		&models.Webhook{},
+		&models.WebhookDelivery{},
+		&models.JobRun{},
+		&models.FileDailyStat{},
+		&models.FileDailyDownloader{},
+		&models.FileDailyBreakdown{},
+		&models.RollupState{},
+		&models.IPHashSalt{},
+		&models.DataExport{},
 	); err != nil {
 		log.Fatalf("❌ Failed to migrate database schema: %v", err)
 	}
+
+	if backfillEmailVerified {
+		if err := DB.Model(&models.User{}).Where("1 = 1").Update("email_verified", true).Error; err != nil {
+			log.Fatalf("❌ Failed to backfill email verification: %v", err)
+		}
+	}
+	if err := migrateOAuthIdentities(DB); err != nil {
+		log.Fatalf("❌ Failed to migrate OAuth identities: %v", err)
+	}
+	if err := reencryptIdentityTokens(DB); err != nil {
+		log.Fatalf("❌ Failed to encrypt OAuth tokens: %v", err)
+	}
+	// files.storage_checksum held S3's own checksum, which was never set
+	// reliably or checked; the SHA-256 and ETag cover integrity.
+	if DB.Migrator().HasColumn(&models.File{}, "storage_checksum") {
+		if err := DB.Migrator().DropColumn(&models.File{}, "storage_checksum"); err != nil {
+			log.Fatalf("❌ Failed to drop files.storage_checksum: %v", err)
+		}
+	}
+	promoteConfiguredAdmins()
 	log.Println("✅ Database connected and migrated successfully")
 }
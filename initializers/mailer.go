@@ -0,0 +1,11 @@
+package initializers
+
+import (
+	"github.com/basit/fileshare-backend/mailer"
+)
+
+var Mailer mailer.Mailer
+
+func InitMailer() {
+	Mailer = mailer.NewFromEnv()
+}
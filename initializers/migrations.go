@@ -0,0 +1,131 @@
+package initializers
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/basit/fileshare-backend/encryption"
+	"github.com/basit/fileshare-backend/models"
+)
+
+// migrateOAuthIdentities moves the per-provider columns that used to live on
+// users into user_identities and then drops them. It is a no-op once the
+// legacy columns are gone.
+func migrateOAuthIdentities(db *gorm.DB) error {
+	legacy := []struct {
+		provider  string
+		idColumn  string
+		access    string
+		refresh   string
+		expiresAt string
+	}{
+		{"google", "google_id", "google_access_token", "google_refresh_token", "google_token_expires_at"},
+		{"github", "git_hub_id", "git_hub_access_token", "NULL", "git_hub_token_expires_at"},
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, l := range legacy {
+			if !tx.Migrator().HasColumn(&models.User{}, l.idColumn) {
+				continue
+			}
+
+			result := tx.Exec(fmt.Sprintf(`
+				INSERT INTO user_identities (user_id, provider, provider_user_id, email, access_token, refresh_token, token_expires_at, created_at, updated_at)
+				SELECT id, ?, %s, email, %s, %s, %s, NOW(), NOW()
+				FROM users
+				WHERE %s IS NOT NULL
+				ON CONFLICT (provider, provider_user_id) DO NOTHING`,
+				l.idColumn, l.access, l.refresh, l.expiresAt, l.idColumn,
+			), l.provider)
+			if result.Error != nil {
+				return fmt.Errorf("failed to copy %s identities: %w", l.provider, result.Error)
+			}
+			log.Printf("Migrated %d %s identities to user_identities", result.RowsAffected, l.provider)
+
+			for _, column := range []string{l.idColumn, l.access, l.refresh, l.expiresAt} {
+				if column == "NULL" || !tx.Migrator().HasColumn(&models.User{}, column) {
+					continue
+				}
+				if err := tx.Migrator().DropColumn(&models.User{}, column); err != nil {
+					return fmt.Errorf("failed to drop users.%s: %w", column, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// reencryptIdentityTokens encrypts plaintext provider tokens and re-seals
+// tokens written with a retired key, so rotating TOKEN_ENCRYPTION_KEYS only
+// needs the new key prepended and a restart. Rows already sealed with the
+// primary key are skipped.
+func reencryptIdentityTokens(db *gorm.DB) error {
+	primary, err := encryption.PrimaryKeyID()
+	if err != nil {
+		return err
+	}
+	current := encryption.EncryptedPrefix(primary) + "%"
+
+	type tokenRow struct {
+		ID           uuid.UUID
+		AccessToken  *string
+		RefreshToken *string
+	}
+
+	reseal := func(value *string) (*string, error) {
+		if value == nil {
+			return nil, nil
+		}
+		plaintext, err := encryption.Decrypt(*value)
+		if err != nil {
+			return nil, err
+		}
+		sealed, err := encryption.Encrypt(plaintext)
+		if err != nil {
+			return nil, err
+		}
+		return &sealed, nil
+	}
+
+	var rows []tokenRow
+	updated := 0
+	result := db.Table("user_identities").
+		Select("id, access_token, refresh_token").
+		Where("(access_token IS NOT NULL AND access_token NOT LIKE ?) OR (refresh_token IS NOT NULL AND refresh_token NOT LIKE ?)", current, current).
+		FindInBatches(&rows, 200, func(tx *gorm.DB, batch int) error {
+			for _, row := range rows {
+				access, err := reseal(row.AccessToken)
+				if err != nil {
+					return fmt.Errorf("identity %s: %w", row.ID, err)
+				}
+				refresh, err := reseal(row.RefreshToken)
+				if err != nil {
+					return fmt.Errorf("identity %s: %w", row.ID, err)
+				}
+
+				// Table-based updates bypass the serializer, so the values
+				// sealed above are written as-is.
+				if err := db.Table("user_identities").
+					Where("id = ?", row.ID).
+					UpdateColumns(map[string]interface{}{
+						"access_token":  access,
+						"refresh_token": refresh,
+					}).Error; err != nil {
+					return err
+				}
+				updated++
+			}
+			return nil
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if updated > 0 {
+		log.Printf("Re-encrypted OAuth tokens for %d identities with key %q", updated, primary)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package initializers
+
+import (
+	"github.com/basit/fileshare-backend/ratelimit"
+)
+
+var RateLimiter ratelimit.RateLimiter
+
+// InitRateLimiter must run after ConnectToDatabase when RATE_LIMIT_STORE is
+// postgres.
+func InitRateLimiter() {
+	RateLimiter = ratelimit.NewFromEnv(DB)
+}
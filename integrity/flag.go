@@ -0,0 +1,51 @@
+package integrity
+
+import (
+	"log"
+	"time"
+
+	"github.com/basit/fileshare-backend/audit"
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+)
+
+// Flag records that file failed an integrity check. Downloads of flagged
+// files are refused until an admin re-uploads or deletes them.
+func Flag(file *models.File, status, detail string) {
+	log.Printf("⚠️  Integrity check failed for file %s (%s): %s", file.ID, status, detail)
+
+	now := time.Now()
+	if err := initializers.DB.Model(file).Updates(map[string]interface{}{
+		"integrity_status":     status,
+		"integrity_checked_at": now,
+		"integrity_detail":     detail,
+	}).Error; err != nil {
+		log.Printf("Failed to flag file %s: %v", file.ID, err)
+	}
+
+	audit.RecordSystem(audit.Event{
+		Action:     audit.ActionFileIntegrityFailed,
+		TargetType: audit.TargetFile,
+		TargetID:   file.ID.String(),
+		Metadata:   map[string]interface{}{"status": status, "detail": detail, "owner": file.UserID},
+	})
+}
+
+// MarkVerified records a successful check. A file uploaded before checksums
+// were stored gets sum and etag as its baseline.
+func MarkVerified(file *models.File, sum, etag string) {
+	updates := map[string]interface{}{
+		"integrity_status":     models.IntegrityOK,
+		"integrity_checked_at": time.Now(),
+		"integrity_detail":     nil,
+	}
+	if file.ChecksumSHA256 == "" {
+		updates["checksum_sha256"] = sum
+	}
+	if file.StorageETag == "" && etag != "" {
+		updates["storage_etag"] = etag
+	}
+	if err := initializers.DB.Model(file).Updates(updates).Error; err != nil {
+		log.Printf("Failed to record integrity check for file %s: %v", file.ID, err)
+	}
+}
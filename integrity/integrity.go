@@ -0,0 +1,53 @@
+package integrity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"strings"
+)
+
+// Verifier hashes everything read through it.
+type Verifier struct {
+	r io.Reader
+	h hash.Hash
+	n int64
+}
+
+func NewVerifier(r io.Reader) *Verifier {
+	return &Verifier{r: r, h: sha256.New()}
+}
+
+func (v *Verifier) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	if n > 0 {
+		v.h.Write(p[:n])
+		v.n += int64(n)
+	}
+	return n, err
+}
+
+// BytesRead reports how much has been read.
+func (v *Verifier) BytesRead() int64 {
+	return v.n
+}
+
+// Sum returns the hex SHA-256 of what has been read so far.
+func (v *Verifier) Sum() string {
+	return hex.EncodeToString(v.h.Sum(nil))
+}
+
+// SHA256 returns the hex SHA-256 of b.
+func SHA256(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeETag strips the quotes S3 puts around ETags.
+func NormalizeETag(etag *string) string {
+	if etag == nil {
+		return ""
+	}
+	return strings.Trim(*etag, `"`)
+}
@@ -5,82 +5,194 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
 
+	"github.com/basit/fileshare-backend/analytics"
+	"github.com/basit/fileshare-backend/audit"
+	"github.com/basit/fileshare-backend/config"
 	"github.com/basit/fileshare-backend/initializers"
 	"github.com/basit/fileshare-backend/models"
+	"github.com/basit/fileshare-backend/webhooks"
 )
 
-func StartCleanupJob() {
-	ticker := time.NewTicker(1 * time.Hour)
-	go func() {
-		for range ticker.C {
-			cleanupExpiredFiles()
-		}
-	}()
-	log.Println("Cleanup job started - runs every hour")
-}
+const (
+	// cleanupPageSize is how many expired rows are loaded at a time.
+	cleanupPageSize = 1000
+
+	// cleanupBatchSize is how many keys go into one DeleteObjects call
+	// (S3 allows up to 1000).
+	cleanupBatchSize = 250
+
+	// Files whose objects keep failing to delete are retried after
+	// cleanupBaseBackoff, doubling per failure up to cleanupMaxBackoff.
+	cleanupBaseBackoff = time.Minute
+	cleanupMaxBackoff  = 24 * time.Hour
+)
 
-func cleanupExpiredFiles() {
+// cleanupConcurrency is how many DeleteObjects batches run at once. Set with
+// CLEANUP_CONCURRENCY (default 4).
+var cleanupConcurrency = config.Int("CLEANUP_CONCURRENCY", 4)
+
+// cleanupExpiredFiles deletes expired files from S3 and the database. It
+// pages through expired rows by ID, so memory use doesn't depend on how many
+// have piled up, and skips files that are backing off after failures.
+func cleanupExpiredFiles(ctx context.Context) (int, error) {
 	log.Println("Starting cleanup of expired files...")
 
-	var expiredFiles []models.File
+	now := time.Now()
+	var cleaned, failed int64
+	var lastID uuid.UUID
+
+	for {
+		var page []models.File
+		query := initializers.DB.
+			Where("expires_at < ?", now).
+			Where("cleanup_next_attempt_at IS NULL OR cleanup_next_attempt_at <= ?", now).
+			Order("id").
+			Limit(cleanupPageSize)
+		if lastID != uuid.Nil {
+			query = query.Where("id > ?", lastID)
+		}
+		if err := query.Find(&page).Error; err != nil {
+			return int(cleaned), fmt.Errorf("error finding expired files: %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		lastID = page[len(page)-1].ID
+
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, cleanupConcurrency)
+		for start := 0; start < len(page); start += cleanupBatchSize {
+			end := start + cleanupBatchSize
+			if end > len(page) {
+				end = len(page)
+			}
+
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(batch []models.File) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				ok, bad := cleanupBatch(ctx, batch)
+				atomic.AddInt64(&cleaned, int64(ok))
+				atomic.AddInt64(&failed, int64(bad))
+			}(page[start:end])
+		}
+		wg.Wait()
 
-	// Find expired files
-	if err := initializers.DB.Where("expires_at < ?", time.Now()).Find(&expiredFiles).Error; err != nil {
-		log.Printf("Error finding expired files: %v", err)
-		return
+		if ctx.Err() != nil {
+			return int(cleaned), ctx.Err()
+		}
+		if len(page) < cleanupPageSize {
+			break
+		}
 	}
 
-	if len(expiredFiles) == 0 {
+	if cleaned == 0 && failed == 0 {
 		log.Println("No expired files found")
-		return
+		return 0, nil
 	}
 
-	log.Printf("Found %d expired files to cleanup", len(expiredFiles))
+	log.Printf("Cleanup completed. Removed %d expired files, %d failed", cleaned, failed)
+	if failed > 0 {
+		return int(cleaned), fmt.Errorf("%d expired files could not be removed", failed)
+	}
+	return int(cleaned), nil
+}
 
-	// Delete files from S3 storage and database
-	for _, file := range expiredFiles {
-		// Delete from S3
-		if err := deleteFileFromS3(file.StoragePath); err != nil {
-			log.Printf("Error deleting file %s from S3: %v", file.StoragePath, err)
-			continue // Skip database deletion if S3 deletion fails
+// cleanupBatch deletes one batch of objects with a single DeleteObjects
+// call, then removes the rows whose objects are gone and records a failure
+// on the rest.
+func cleanupBatch(ctx context.Context, files []models.File) (int, int) {
+	objects := make([]types.ObjectIdentifier, 0, len(files))
+	for _, file := range files {
+		objects = append(objects, types.ObjectIdentifier{Key: aws.String(file.StoragePath)})
+	}
+
+	failures := make(map[string]string)
+	out, err := initializers.S3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(getBucketName()),
+		Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+	})
+	if err != nil {
+		for _, file := range files {
+			failures[file.StoragePath] = err.Error()
 		}
+	} else {
+		for _, e := range out.Errors {
+			failures[aws.ToString(e.Key)] = fmt.Sprintf("%s: %s", aws.ToString(e.Code), aws.ToString(e.Message))
+		}
+	}
 
-		// Delete associated download events first (foreign key constraint)
-		if err := initializers.DB.Where("file_id = ?", file.ID).Delete(&models.DownloadEvent{}).Error; err != nil {
-			log.Printf("Error deleting download events for file %s: %v", file.ID, err)
+	var deleted []models.File
+	for _, file := range files {
+		if msg, ok := failures[file.StoragePath]; ok {
+			recordCleanupFailure(&file, msg)
+			continue
 		}
+		deleted = append(deleted, file)
+	}
+	if len(deleted) == 0 {
+		return 0, len(files)
+	}
+
+	ids := make([]uuid.UUID, 0, len(deleted))
+	for _, file := range deleted {
+		ids = append(ids, file.ID)
+	}
 
-		// Delete from database
-		if err := initializers.DB.Delete(&file).Error; err != nil {
-			log.Printf("Error deleting file %s from database: %v", file.ID, err)
-		} else {
-			log.Printf("Successfully cleaned up expired file: %s (Original: %s)", file.ID, file.OriginalName)
+	// Delete associated download events first (foreign key constraint)
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := analytics.DeleteFileData(tx, ids); err != nil {
+			return err
 		}
+		return tx.Where("id IN ?", ids).Delete(&models.File{}).Error
+	})
+	if err != nil {
+		// The objects are gone; the rows go on the next run.
+		log.Printf("Error deleting %d expired files from database: %v", len(ids), err)
+		return 0, len(files)
+	}
+
+	for i := range deleted {
+		file := &deleted[i]
+		audit.RecordSystem(audit.Event{
+			Action:     audit.ActionFileExpired,
+			TargetType: audit.TargetFile,
+			TargetID:   file.ID.String(),
+			Metadata:   map[string]interface{}{"name": file.OriginalName, "owner": file.UserID},
+		})
+		webhooks.Dispatch(file.UserID, webhooks.EventFileExpired, webhooks.FileData(file))
 	}
 
-	log.Printf("Cleanup completed. Processed %d expired files", len(expiredFiles))
+	return len(deleted), len(files) - len(deleted)
 }
 
-// deleteFileFromS3 deletes a single file from S3 storage
-func deleteFileFromS3(key string) error {
-	s3Client := initializers.S3Client
+func recordCleanupFailure(file *models.File, msg string) {
+	failures := file.CleanupFailures + 1
+	backoff := cleanupBaseBackoff << (failures - 1)
+	if backoff > cleanupMaxBackoff || backoff <= 0 {
+		backoff = cleanupMaxBackoff
+	}
 
-	_, err := s3Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-		Bucket: aws.String(getBucketName()),
-		Key:    aws.String(key),
-	})
+	log.Printf("Error deleting file %s from S3 (attempt %d, retrying in %v): %s", file.StoragePath, failures, backoff, msg)
 
-	if err != nil {
-		return fmt.Errorf("failed to delete S3 object %s: %w", key, err)
+	if err := initializers.DB.Model(file).Updates(map[string]interface{}{
+		"cleanup_failures":        failures,
+		"cleanup_last_error":      msg,
+		"cleanup_next_attempt_at": time.Now().Add(backoff),
+	}).Error; err != nil {
+		log.Printf("Error recording cleanup failure for file %s: %v", file.ID, err)
 	}
-
-	log.Printf("Successfully deleted file from S3: %s", key)
-	return nil
 }
 
 // getBucketName returns the S3 bucket name from environment or initializers
@@ -93,24 +205,9 @@ func getBucketName() string {
 	return initializers.S3Bucket
 }
 
-// Optional: Cleanup job with configurable interval
-func StartCleanupJobWithInterval(interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	go func() {
-		// Run cleanup immediately on start
-		cleanupExpiredFiles()
-
-		// Then run on schedule
-		for range ticker.C {
-			cleanupExpiredFiles()
-		}
-	}()
-	log.Printf("Cleanup job started - runs every %v", interval)
-}
-
-// Optional: Manual cleanup trigger for admin endpoints
+// RunCleanupNow runs the cleanup job immediately, for admin endpoints.
 func RunCleanupNow() error {
 	log.Println("Manual cleanup triggered")
-	cleanupExpiredFiles()
-	return nil
+	_, err := Default.RunNow(context.Background(), JobCleanup)
+	return err
 }
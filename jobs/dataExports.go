@@ -0,0 +1,232 @@
+package jobs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"github.com/lithammer/shortuuid/v4"
+
+	"github.com/basit/fileshare-backend/auth"
+	"github.com/basit/fileshare-backend/config"
+	"github.com/basit/fileshare-backend/export"
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/integrity"
+	"github.com/basit/fileshare-backend/mailer"
+	"github.com/basit/fileshare-backend/models"
+	"github.com/basit/fileshare-backend/notifications"
+)
+
+var (
+	ErrDataExportInProgress = errors.New("a data export is already in progress")
+	ErrDataExportTooSoon    = errors.New("a data export was already made in the last 24 hours")
+)
+
+// DataExportTTL is how long a finished archive and its link last. Set with
+// DATA_EXPORT_TTL (default 72h).
+var DataExportTTL = config.Duration("DATA_EXPORT_TTL", 72*time.Hour)
+
+const (
+	dataExportCooldown    = 24 * time.Hour
+	dataExportMaxAttempts = 3
+	// dataExportLease is how long a running export may go without finishing
+	// before another instance assumes it died and takes it over.
+	dataExportLease = 2 * time.Hour
+	// dataExportsPerRun bounds how many archives one run builds.
+	dataExportsPerRun = 5
+)
+
+// RequestDataExport queues a data export for userID. Users get one export
+// at a time and at most one a day.
+func RequestDataExport(userID uuid.UUID) (*models.DataExport, error) {
+	var recent []models.DataExport
+	if err := initializers.DB.
+		Where("user_id = ? AND (status IN ? OR (status = ? AND created_at > ?))",
+			userID, []string{models.DataExportPending, models.DataExportRunning},
+			models.DataExportReady, time.Now().Add(-dataExportCooldown)).
+		Limit(1).
+		Find(&recent).Error; err != nil {
+		return nil, fmt.Errorf("failed to check data exports: %v", err)
+	}
+	if len(recent) > 0 {
+		if recent[0].Status == models.DataExportReady {
+			return nil, ErrDataExportTooSoon
+		}
+		return nil, ErrDataExportInProgress
+	}
+
+	exp := models.DataExport{
+		ID:     uuid.New(),
+		UserID: userID,
+		Status: models.DataExportPending,
+	}
+	if err := initializers.DB.Create(&exp).Error; err != nil {
+		return nil, fmt.Errorf("failed to queue data export: %v", err)
+	}
+	return &exp, nil
+}
+
+// processDataExports builds queued data exports.
+func processDataExports(ctx context.Context) (int, error) {
+	done := 0
+	for done < dataExportsPerRun {
+		if ctx.Err() != nil {
+			return done, ctx.Err()
+		}
+
+		var claimed []models.DataExport
+		err := initializers.DB.Raw(`
+UPDATE data_exports
+SET status = ?, started_at = ?, attempts = attempts + 1
+WHERE id IN (
+	SELECT id FROM data_exports
+	WHERE status = ? OR (status = ? AND started_at < ?)
+	ORDER BY created_at
+	LIMIT 1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`,
+			models.DataExportRunning, time.Now(),
+			models.DataExportPending, models.DataExportRunning, time.Now().Add(-dataExportLease),
+		).Scan(&claimed).Error
+		if err != nil {
+			return done, fmt.Errorf("error claiming data exports: %v", err)
+		}
+		if len(claimed) == 0 {
+			return done, nil
+		}
+
+		exp := &claimed[0]
+		if err := buildDataExport(ctx, exp); err != nil {
+			log.Printf("Data export %s failed (attempt %d): %v", exp.ID, exp.Attempts, err)
+			status := models.DataExportPending
+			if exp.Attempts >= dataExportMaxAttempts {
+				status = models.DataExportFailed
+			}
+			initializers.DB.Model(exp).Updates(map[string]interface{}{
+				"status": status,
+				"error":  err.Error(),
+			})
+			continue
+		}
+		done++
+	}
+	return done, nil
+}
+
+func buildDataExport(ctx context.Context, exp *models.DataExport) error {
+	var user models.User
+	if err := initializers.DB.First(&user, "id = ?", exp.UserID).Error; err != nil {
+		return fmt.Errorf("error loading user: %v", err)
+	}
+
+	name := fmt.Sprintf("fileshare-export-%s.zip", time.Now().UTC().Format("20060102"))
+	key := uuid.New().String() + "_" + name
+
+	// The archive is streamed straight into a multipart upload.
+	hasher := sha256.New()
+	size := &byteCounter{}
+	pr, pw := io.Pipe()
+	go func() {
+		w := io.MultiWriter(pw, hasher, size)
+		pw.CloseWithError(export.WriteArchive(ctx, initializers.DB, &user, w))
+	}()
+
+	out, err := manager.NewUploader(initializers.S3Client).Upload(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(initializers.S3Bucket),
+		Key:         aws.String(key),
+		Body:        pr,
+		ContentType: aws.String("application/zip"),
+	})
+	pr.CloseWithError(err)
+	if err != nil {
+		return fmt.Errorf("error uploading archive: %v", err)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(DataExportTTL)
+	slug := shortuuid.New()
+	file := models.File{
+		ID:           uuid.New(),
+		OriginalName: name,
+		StoragePath:  key,
+		// FileSize is only informational; downloads use the object's length.
+		FileSize:     int32(min(size.n, math.MaxInt32)),
+		DownloadSlug: slug,
+		CreatedAt:    now,
+		UserID:       &user.ID,
+		ExpiresAt:    &expiresAt,
+		IsPublic:     false,
+		ContentType:  "application/zip",
+		Kind:         models.FileKindDataExport,
+
+		ChecksumSHA256:  hex.EncodeToString(hasher.Sum(nil)),
+		StorageETag:     integrity.NormalizeETag(out.ETag),
+		IntegrityStatus: models.IntegrityOK,
+	}
+	if err := initializers.DB.Create(&file).Error; err != nil {
+		if _, delErr := initializers.S3Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+			Bucket: aws.String(initializers.S3Bucket),
+			Key:    aws.String(key),
+		}); delErr != nil {
+			log.Printf("Failed to remove S3 object %s after DB error: %v", key, delErr)
+		}
+		return fmt.Errorf("error saving archive: %v", err)
+	}
+
+	if err := initializers.DB.Model(exp).Updates(map[string]interface{}{
+		"status":       models.DataExportReady,
+		"file_id":      file.ID,
+		"error":        "",
+		"completed_at": now,
+		"expires_at":   expiresAt,
+	}).Error; err != nil {
+		return fmt.Errorf("error updating data export: %v", err)
+	}
+
+	link, err := DataExportLink(file.ID, expiresAt)
+	if err != nil {
+		log.Printf("Error signing link for data export %s: %v", exp.ID, err)
+		return nil
+	}
+	if _, err := notifications.Create(user.ID, models.NotificationDataExportReady,
+		"Your data export is ready",
+		fmt.Sprintf("Download it before %s.", expiresAt.UTC().Format(time.RFC1123)),
+		map[string]interface{}{"exportId": exp.ID.String(), "url": link, "expiresAt": expiresAt},
+	); err != nil {
+		log.Printf("Error notifying user %s of data export: %v", user.ID, err)
+	}
+	if err := initializers.Mailer.Send(ctx, mailer.DataExportReadyEmail(user.Email, link, expiresAt)); err != nil {
+		log.Printf("Error emailing user %s about data export: %v", user.ID, err)
+	}
+	return nil
+}
+
+// DataExportLink returns the expiring download link for an archive.
+func DataExportLink(fileID uuid.UUID, expiresAt time.Time) (string, error) {
+	token, err := auth.IssueDataExportToken(fileID, expiresAt)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/api/exports/takeout/%s", os.Getenv("BASE_URL"), token), nil
+}
+
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,149 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/mailer"
+	"github.com/basit/fileshare-backend/models"
+	"github.com/basit/fileshare-backend/notifications"
+)
+
+// digestBatchSize caps how many queued downloads one run claims, so a burst
+// of traffic is spread over several runs instead of loaded at once.
+const digestBatchSize = 5000
+
+// digestClaimLease is how long claimed downloads stay hidden from other runs.
+// Downloads whose digest couldn't be sent are retried once it runs out.
+const digestClaimLease = 10 * time.Minute
+
+type claimedAlert struct {
+	ID       uuid.UUID
+	OwnerID  uuid.UUID
+	FileID   uuid.UUID
+	FileName string
+}
+
+// sendDownloadDigests sends one digest per owner with queued downloads.
+// Each owner gets at most one email and one notification per run, however
+// many downloads happened.
+func sendDownloadDigests(ctx context.Context) (int, error) {
+	processed := 0
+	for {
+		// Claimed downloads are leased rather than deleted, so a digest
+		// that fails to send is retried instead of lost. Two instances
+		// never claim the same downloads at once.
+		var alerts []claimedAlert
+		err := initializers.DB.Raw(`
+UPDATE pending_download_alerts SET claimed_at = ?
+WHERE id IN (
+	SELECT id FROM pending_download_alerts
+	WHERE claimed_at IS NULL OR claimed_at < ?
+	ORDER BY created_at
+	LIMIT ?
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING id, owner_id, file_id, file_name`, time.Now(), time.Now().Add(-digestClaimLease), digestBatchSize).
+			Scan(&alerts).Error
+		if err != nil {
+			return processed, fmt.Errorf("error claiming download alerts: %v", err)
+		}
+		if len(alerts) == 0 {
+			return processed, nil
+		}
+		processed += len(alerts)
+
+		byOwner := make(map[uuid.UUID][]claimedAlert)
+		for _, a := range alerts {
+			byOwner[a.OwnerID] = append(byOwner[a.OwnerID], a)
+		}
+		for ownerID, ownerAlerts := range byOwner {
+			if err := sendDownloadDigest(ctx, ownerID, ownerAlerts); err != nil {
+				log.Printf("Error sending download digest to user %s: %v", ownerID, err)
+				continue
+			}
+			ids := make([]uuid.UUID, 0, len(ownerAlerts))
+			for _, a := range ownerAlerts {
+				ids = append(ids, a.ID)
+			}
+			if err := initializers.DB.Where("id IN ?", ids).Delete(&models.PendingDownloadAlert{}).Error; err != nil {
+				log.Printf("Error removing sent download alerts for user %s: %v", ownerID, err)
+			}
+		}
+
+		if len(alerts) < digestBatchSize {
+			return processed, nil
+		}
+	}
+}
+
+func sendDownloadDigest(ctx context.Context, ownerID uuid.UUID, alerts []claimedAlert) error {
+	var owner models.User
+	if err := initializers.DB.First(&owner, "id = ?", ownerID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// The account is gone; its queued downloads are dropped.
+			return nil
+		}
+		return fmt.Errorf("error loading owner: %v", err)
+	}
+	// The owner may have switched alerts off since the downloads were queued.
+	if !owner.DownloadAlerts {
+		return nil
+	}
+
+	counts := make(map[uuid.UUID]*mailer.DigestFile)
+	var order []uuid.UUID
+	for _, a := range alerts {
+		if f, ok := counts[a.FileID]; ok {
+			f.Downloads++
+			continue
+		}
+		counts[a.FileID] = &mailer.DigestFile{Name: a.FileName, Downloads: 1}
+		order = append(order, a.FileID)
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return counts[order[i]].Downloads > counts[order[j]].Downloads
+	})
+
+	files := make([]mailer.DigestFile, 0, len(order))
+	data := make([]map[string]interface{}, 0, len(order))
+	for _, id := range order {
+		files = append(files, *counts[id])
+		data = append(data, map[string]interface{}{
+			"fileId":    id.String(),
+			"name":      counts[id].Name,
+			"downloads": counts[id].Downloads,
+		})
+	}
+
+	title := fmt.Sprintf("%s was downloaded", files[0].Name)
+	if len(alerts) > 1 {
+		title = fmt.Sprintf("Your files were downloaded %d times", len(alerts))
+	}
+	body := fmt.Sprintf("%d download(s) across %d file(s)", len(alerts), len(files))
+
+	// The email goes first: if it fails the downloads are retried, and the
+	// notification must not be created twice.
+	settingsLink := fmt.Sprintf("%s/settings/notifications", os.Getenv("BASE_URL"))
+	msg := mailer.DownloadDigestEmail(owner.Email, len(alerts), files, settingsLink)
+	if err := initializers.Mailer.Send(ctx, msg); err != nil {
+		return err
+	}
+
+	if _, err := notifications.Create(owner.ID, models.NotificationDownloadDigest, title, body, map[string]interface{}{
+		"total": len(alerts),
+		"files": data,
+	}); err != nil {
+		log.Printf("Error creating download notification for user %s: %v", owner.ID, err)
+	}
+	return nil
+}
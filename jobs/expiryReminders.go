@@ -0,0 +1,133 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"github.com/basit/fileshare-backend/auth"
+	"github.com/basit/fileshare-backend/config"
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/mailer"
+	"github.com/basit/fileshare-backend/models"
+)
+
+// ExpiryExtension is how much longer a file is kept when its owner follows
+// the link in a reminder. Set with EXPIRY_EXTENSION (default 168h).
+var ExpiryExtension = config.Duration("EXPIRY_EXTENSION", 7*24*time.Hour)
+
+// reminderWindows returns the configured windows, smallest first.
+func reminderWindows() []time.Duration {
+	value := os.Getenv("EXPIRY_REMINDER_WINDOWS")
+	if value == "" {
+		value = "24h,1h"
+	}
+
+	var windows []time.Duration
+	for _, part := range strings.Split(value, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil || d <= 0 {
+			log.Printf("⚠️  Ignoring expiry reminder window %q", part)
+			continue
+		}
+		windows = append(windows, d)
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i] < windows[j] })
+	return windows
+}
+
+// sendExpiryReminders emails owners who opted in to expiry reminders when
+// a file enters one of the windows.
+func sendExpiryReminders(ctx context.Context, windows []time.Duration) (int, error) {
+	if len(windows) == 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+
+	// Reminders for expiries that have passed can never be due again.
+	if err := initializers.DB.Where("expires_at < ?", now.Add(-24*time.Hour)).
+		Delete(&models.ExpiryReminder{}).Error; err != nil {
+		log.Printf("Error pruning expiry reminders: %v", err)
+	}
+
+	var files []models.File
+	if err := initializers.DB.
+		Preload("User").
+		Joins("JOIN users ON users.id = files.user_id").
+		Where("users.expiry_reminders = ?", true).
+		Where("files.expires_at > ? AND files.expires_at <= ?", now, now.Add(windows[len(windows)-1])).
+		// Data export archives are meant to expire.
+		Scopes(models.Uploads).
+		Find(&files).Error; err != nil {
+		return 0, fmt.Errorf("error finding files due for expiry reminders: %v", err)
+	}
+
+	sent := 0
+	for _, file := range files {
+		due, err := claimExpiryReminder(&file, windows, now)
+		if err != nil {
+			log.Printf("Error recording expiry reminder for file %s: %v", file.ID, err)
+			continue
+		}
+		if !due {
+			continue
+		}
+		if err := sendExpiryReminder(ctx, &file); err != nil {
+			log.Printf("Error sending expiry reminder for file %s: %v", file.ID, err)
+			continue
+		}
+		sent++
+	}
+
+	if sent > 0 {
+		log.Printf("Sent %d expiry reminders", sent)
+	}
+	return sent, nil
+}
+
+// claimExpiryReminder records a reminder for the smallest window the file
+// is inside, and reports whether it still had to be sent. Larger windows are
+// marked too, so a file uploaded with a short expiry gets one email rather
+// than one per window.
+func claimExpiryReminder(file *models.File, windows []time.Duration, now time.Time) (bool, error) {
+	remaining := file.ExpiresAt.Sub(now)
+
+	due, first := false, true
+	for _, w := range windows {
+		if w < remaining {
+			continue
+		}
+		result := initializers.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.ExpiryReminder{
+			FileID:        file.ID,
+			WindowSeconds: int64(w / time.Second),
+			ExpiresAt:     *file.ExpiresAt,
+			SentAt:        now,
+		})
+		if result.Error != nil {
+			return false, result.Error
+		}
+		if first {
+			due = result.RowsAffected == 1
+			first = false
+		}
+	}
+	return due, nil
+}
+
+func sendExpiryReminder(ctx context.Context, file *models.File) error {
+	token, err := auth.IssueExtendExpiryToken(file.ID, *file.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	extendLink := fmt.Sprintf("%s/api/files/extend/%s", os.Getenv("BASE_URL"), token)
+	msg := mailer.ExpiryReminderEmail(file.User.Email, file.OriginalName, *file.ExpiresAt, extendLink, ExpiryExtension)
+	return initializers.Mailer.Send(ctx, msg)
+}
@@ -0,0 +1,187 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/basit/fileshare-backend/analytics"
+	"github.com/basit/fileshare-backend/auth"
+	"github.com/basit/fileshare-backend/config"
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+	"github.com/basit/fileshare-backend/webhooks"
+)
+
+// Job names, as used in job_runs and by RunNow.
+const (
+	JobCleanup         = "cleanup-expired-files"
+	JobDownloadAlerts  = "download-alerts"
+	JobExpiryReminders = "expiry-reminders"
+	JobWebhookDelivery = "webhook-delivery"
+	JobPruneJobRuns    = "prune-job-runs"
+	JobReconcile       = "reconcile-storage"
+	JobScrub           = "integrity-scrub"
+	JobAnalyticsRollup = "analytics-rollup"
+	JobPurgeEvents     = "purge-download-events"
+	JobAnonymizeEvents = "anonymize-download-events"
+	JobDataExports     = "data-exports"
+	JobPruneThrottles  = "prune-auth-throttles"
+)
+
+// Default is the scheduler holding the application's jobs.
+var Default = NewScheduler()
+
+// Start registers the application's jobs and starts the scheduler. Intervals
+// can be tuned with:
+//
+//	CLEANUP_INTERVAL                default 1h
+//	DOWNLOAD_ALERT_INTERVAL         default 5m
+//	EXPIRY_REMINDER_INTERVAL        default 10m
+//	WEBHOOK_POLL_INTERVAL           default 5s
+//	JOB_RUN_RETENTION               default 720h
+//	RECONCILE_INTERVAL              default 24h
+//	RECONCILE_MODE                  dry-run (default), delete or quarantine
+//	SCRUB_INTERVAL                  default 24h
+//	ANALYTICS_ROLLUP_INTERVAL       default 15m
+//	DOWNLOAD_EVENT_RETENTION        default 2160h, at least 48h
+//	DOWNLOAD_EVENT_ANONYMIZE_AFTER  default 720h, at least 48h
+//	DATA_EXPORT_INTERVAL            default 1m
+func Start(ctx context.Context) {
+	windows := reminderWindows()
+	retention := config.Duration("JOB_RUN_RETENTION", 30*24*time.Hour)
+	eventRetention := config.Duration("DOWNLOAD_EVENT_RETENTION", 90*24*time.Hour)
+	if eventRetention < analytics.MinRetention {
+		log.Printf("⚠️  DOWNLOAD_EVENT_RETENTION below %s, using %s", analytics.MinRetention, analytics.MinRetention)
+		eventRetention = analytics.MinRetention
+	}
+	anonymizeAfter := config.Duration("DOWNLOAD_EVENT_ANONYMIZE_AFTER", 30*24*time.Hour)
+	if anonymizeAfter < analytics.MinRetention {
+		log.Printf("⚠️  DOWNLOAD_EVENT_ANONYMIZE_AFTER below %s, using %s", analytics.MinRetention, analytics.MinRetention)
+		anonymizeAfter = analytics.MinRetention
+	}
+
+	reconcileMode, err := ParseReconcileMode(os.Getenv("RECONCILE_MODE"))
+	if err != nil {
+		log.Printf("⚠️  %v, using %s", err, ReconcileDryRun)
+		reconcileMode = ReconcileDryRun
+	}
+
+	Default.Register(&Job{
+		Name:     JobCleanup,
+		Interval: config.Duration("CLEANUP_INTERVAL", time.Hour),
+		Jitter:   5 * time.Minute,
+		Run:      cleanupExpiredFiles,
+	})
+	Default.Register(&Job{
+		Name:          JobDownloadAlerts,
+		Interval:      config.Duration("DOWNLOAD_ALERT_INTERVAL", 5*time.Minute),
+		Jitter:        30 * time.Second,
+		SkipEmptyRuns: true,
+		Run:           sendDownloadDigests,
+	})
+	Default.Register(&Job{
+		Name:          JobExpiryReminders,
+		Interval:      config.Duration("EXPIRY_REMINDER_INTERVAL", 10*time.Minute),
+		Jitter:        time.Minute,
+		SkipEmptyRuns: true,
+		Run: func(ctx context.Context) (int, error) {
+			return sendExpiryReminders(ctx, windows)
+		},
+	})
+	Default.Register(&Job{
+		Name:          JobWebhookDelivery,
+		Interval:      config.Duration("WEBHOOK_POLL_INTERVAL", 5*time.Second),
+		Jitter:        time.Second,
+		SkipEmptyRuns: true,
+		Run: func(ctx context.Context) (int, error) {
+			return webhooks.DeliverDue(), nil
+		},
+	})
+	Default.Register(&Job{
+		Name:          JobPruneJobRuns,
+		Interval:      24 * time.Hour,
+		Jitter:        time.Hour,
+		SkipEmptyRuns: true,
+		Run: func(ctx context.Context) (int, error) {
+			return pruneJobRuns(retention)
+		},
+	})
+
+	Default.Register(&Job{
+		Name:          JobPruneThrottles,
+		Interval:      6 * time.Hour,
+		Jitter:        30 * time.Minute,
+		SkipEmptyRuns: true,
+		Run:           auth.PruneThrottles,
+	})
+
+	Default.Register(&Job{
+		Name:     JobReconcile,
+		Interval: config.Duration("RECONCILE_INTERVAL", 24*time.Hour),
+		Jitter:   time.Hour,
+		Run: func(ctx context.Context) (int, error) {
+			report, err := reconcile(ctx, reconcileMode)
+			if err != nil {
+				return 0, err
+			}
+			if len(report.Errors) > 0 {
+				return report.OrphanCount + report.MissingCount, fmt.Errorf("%d errors resolving orphans, first: %s", len(report.Errors), report.Errors[0])
+			}
+			return report.OrphanCount + report.MissingCount, nil
+		},
+	})
+
+	Default.Register(&Job{
+		Name:     JobScrub,
+		Interval: config.Duration("SCRUB_INTERVAL", 24*time.Hour),
+		Jitter:   time.Hour,
+		Run:      scrubFiles,
+	})
+
+	Default.Register(&Job{
+		Name:     JobAnalyticsRollup,
+		Interval: config.Duration("ANALYTICS_ROLLUP_INTERVAL", 15*time.Minute),
+		Jitter:   time.Minute,
+		Run:      analytics.Rollup,
+	})
+	Default.Register(&Job{
+		Name:          JobPurgeEvents,
+		Interval:      24 * time.Hour,
+		Jitter:        time.Hour,
+		SkipEmptyRuns: true,
+		Run: func(ctx context.Context) (int, error) {
+			return analytics.PurgeEvents(ctx, eventRetention)
+		},
+	})
+	Default.Register(&Job{
+		Name:     JobAnonymizeEvents,
+		Interval: 6 * time.Hour,
+		Jitter:   30 * time.Minute,
+		Run: func(ctx context.Context) (int, error) {
+			return analytics.AnonymizeEvents(ctx, anonymizeAfter)
+		},
+	})
+
+	Default.Register(&Job{
+		Name:          JobDataExports,
+		Interval:      config.Duration("DATA_EXPORT_INTERVAL", time.Minute),
+		Jitter:        10 * time.Second,
+		SkipEmptyRuns: true,
+		Run:           processDataExports,
+	})
+
+	Default.Start(ctx)
+}
+
+func pruneJobRuns(retention time.Duration) (int, error) {
+	result := initializers.DB.
+		Where("started_at < ? AND status <> ?", time.Now().Add(-retention), models.JobRunRunning).
+		Delete(&models.JobRun{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("error pruning job runs: %v", result.Error)
+	}
+	return int(result.RowsAffected), nil
+}
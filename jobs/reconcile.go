@@ -0,0 +1,302 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/basit/fileshare-backend/config"
+	"github.com/basit/fileshare-backend/initializers"
+)
+
+// ReconcileMode says what Reconcile does with orphaned objects.
+type ReconcileMode string
+
+const (
+	// ReconcileDryRun only reports.
+	ReconcileDryRun ReconcileMode = "dry-run"
+	// ReconcileDelete deletes objects that have no files row.
+	ReconcileDelete ReconcileMode = "delete"
+	// ReconcileQuarantine moves objects that have no files row under
+	// QuarantinePrefix, where they can be inspected or restored.
+	ReconcileQuarantine ReconcileMode = "quarantine"
+)
+
+const (
+	// QuarantinePrefix holds quarantined objects. It is never reconciled.
+	QuarantinePrefix = "quarantine/"
+
+	// reconcileSampleSize caps how many keys each report lists; the counts
+	// are always complete.
+	reconcileSampleSize = 100
+
+	reconcilePageSize = 1000
+)
+
+// ReconcileReport is the outcome of one reconciliation.
+type ReconcileReport struct {
+	Mode           ReconcileMode
+	ObjectsScanned int
+	RowsScanned    int
+
+	// OrphanObjects are bucket keys with no files row.
+	OrphanCount   int
+	OrphanObjects []string
+
+	// MissingObjects are files.storage_path values with no object.
+	MissingCount   int
+	MissingObjects []string
+
+	Deleted     int
+	Quarantined int
+	Errors      []string
+}
+
+// reconcileGrace skips objects newer than this, since uploads reach the
+// bucket before their row is inserted. Set with RECONCILE_GRACE (default 1h).
+var reconcileGrace = config.Duration("RECONCILE_GRACE", time.Hour)
+
+func ParseReconcileMode(value string) (ReconcileMode, error) {
+	switch mode := ReconcileMode(value); mode {
+	case ReconcileDryRun, ReconcileDelete, ReconcileQuarantine:
+		return mode, nil
+	case "":
+		return ReconcileDryRun, nil
+	}
+	return "", fmt.Errorf("unknown reconcile mode %q", value)
+}
+
+// Reconcile compares the bucket with the files table, for admin commands.
+// It fails with ErrJobRunning if the scheduled reconciliation is running.
+func Reconcile(ctx context.Context, mode ReconcileMode) (*ReconcileReport, error) {
+	conn, err := tryAdvisoryLock(ctx, jobLockKey(JobReconcile))
+	if err != nil {
+		return nil, err
+	}
+	defer releaseAdvisoryLock(conn, jobLockKey(JobReconcile))
+
+	return reconcile(ctx, mode)
+}
+
+// reconcile walks the bucket listing and the files table side by side. Both
+// are in byte order, so they merge like two sorted lists and neither has to
+// be held in memory.
+func reconcile(ctx context.Context, mode ReconcileMode) (*ReconcileReport, error) {
+	report := &ReconcileReport{Mode: mode}
+	cutoff := time.Now().Add(-reconcileGrace)
+
+	objects := newObjectLister(ctx)
+	rows := newPathLister()
+
+	obj, err := objects.next()
+	if err != nil {
+		return nil, err
+	}
+	path, err := rows.next()
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []types.Object
+	for obj != nil || path != nil {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		switch {
+		case path == nil || (obj != nil && aws.ToString(obj.Key) < *path):
+			report.ObjectsScanned++
+			if aws.ToTime(obj.LastModified).Before(cutoff) {
+				report.OrphanCount++
+				if len(report.OrphanObjects) < reconcileSampleSize {
+					report.OrphanObjects = append(report.OrphanObjects, aws.ToString(obj.Key))
+				}
+				orphans = append(orphans, *obj)
+			}
+			if obj, err = objects.next(); err != nil {
+				return report, err
+			}
+
+		case obj == nil || *path < aws.ToString(obj.Key):
+			report.RowsScanned++
+			report.MissingCount++
+			if len(report.MissingObjects) < reconcileSampleSize {
+				report.MissingObjects = append(report.MissingObjects, *path)
+			}
+			if path, err = rows.next(); err != nil {
+				return report, err
+			}
+
+		default:
+			report.ObjectsScanned++
+			report.RowsScanned++
+			if obj, err = objects.next(); err != nil {
+				return report, err
+			}
+			if path, err = rows.next(); err != nil {
+				return report, err
+			}
+		}
+
+		if len(orphans) >= cleanupBatchSize {
+			resolveOrphans(ctx, mode, orphans, report)
+			orphans = orphans[:0]
+		}
+	}
+	resolveOrphans(ctx, mode, orphans, report)
+
+	log.Printf("Storage reconciliation (%s): %d objects, %d rows, %d orphan objects, %d missing objects, %d deleted, %d quarantined",
+		mode, report.ObjectsScanned, report.RowsScanned, report.OrphanCount, report.MissingCount, report.Deleted, report.Quarantined)
+	return report, nil
+}
+
+// copySource builds a CopyObject source, which must be URL-encoded. Each
+// segment is escaped separately so the "/" separators survive.
+func copySource(bucket, key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return bucket + "/" + strings.Join(segments, "/")
+}
+
+func resolveOrphans(ctx context.Context, mode ReconcileMode, orphans []types.Object, report *ReconcileReport) {
+	if len(orphans) == 0 || mode == ReconcileDryRun {
+		return
+	}
+
+	bucket := getBucketName()
+	var toDelete []types.ObjectIdentifier
+	for _, obj := range orphans {
+		if mode == ReconcileQuarantine {
+			_, err := initializers.S3Client.CopyObject(ctx, &s3.CopyObjectInput{
+				Bucket:     aws.String(bucket),
+				CopySource: aws.String(copySource(bucket, aws.ToString(obj.Key))),
+				Key:        aws.String(QuarantinePrefix + aws.ToString(obj.Key)),
+			})
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("quarantine %s: %v", aws.ToString(obj.Key), err))
+				continue
+			}
+		}
+		toDelete = append(toDelete, types.ObjectIdentifier{Key: obj.Key})
+	}
+	if len(toDelete) == 0 {
+		return
+	}
+
+	out, err := initializers.S3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &types.Delete{Objects: toDelete, Quiet: aws.Bool(true)},
+	})
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("delete batch: %v", err))
+		return
+	}
+	for _, e := range out.Errors {
+		report.Errors = append(report.Errors, fmt.Sprintf("delete %s: %s", aws.ToString(e.Key), aws.ToString(e.Message)))
+	}
+
+	done := len(toDelete) - len(out.Errors)
+	if mode == ReconcileQuarantine {
+		report.Quarantined += done
+	} else {
+		report.Deleted += done
+	}
+}
+
+// objectLister pages through the bucket in key order, skipping quarantine.
+type objectLister struct {
+	ctx       context.Context
+	paginator *s3.ListObjectsV2Paginator
+	page      []types.Object
+}
+
+func newObjectLister(ctx context.Context) *objectLister {
+	return &objectLister{
+		ctx: ctx,
+		paginator: s3.NewListObjectsV2Paginator(initializers.S3Client, &s3.ListObjectsV2Input{
+			Bucket:  aws.String(getBucketName()),
+			MaxKeys: aws.Int32(reconcilePageSize),
+		}),
+	}
+}
+
+func (l *objectLister) next() (*types.Object, error) {
+	for {
+		for len(l.page) > 0 {
+			obj := l.page[0]
+			l.page = l.page[1:]
+			if !strings.HasPrefix(aws.ToString(obj.Key), QuarantinePrefix) {
+				return &obj, nil
+			}
+		}
+		if !l.paginator.HasMorePages() {
+			return nil, nil
+		}
+		out, err := l.paginator.NextPage(l.ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list bucket: %v", err)
+		}
+		l.page = out.Contents
+	}
+}
+
+// pathLister pages through files.storage_path in the same byte order S3
+// lists keys in.
+type pathLister struct {
+	last     string
+	page     []string
+	done     bool
+	returned *string
+}
+
+func newPathLister() *pathLister {
+	return &pathLister{}
+}
+
+// next returns the next distinct path, or nil when there are no more.
+func (l *pathLister) next() (*string, error) {
+	for {
+		path, err := l.nextRow()
+		if err != nil || path == nil {
+			return path, err
+		}
+		if l.returned == nil || *path != *l.returned {
+			l.returned = path
+			return path, nil
+		}
+	}
+}
+
+func (l *pathLister) nextRow() (*string, error) {
+	if len(l.page) == 0 && !l.done {
+		var page []string
+		if err := initializers.DB.
+			Table("files").
+			Where(`storage_path COLLATE "C" > ?`, l.last).
+			Order(`storage_path COLLATE "C"`).
+			Limit(reconcilePageSize).
+			Pluck("storage_path", &page).Error; err != nil {
+			return nil, fmt.Errorf("failed to list files: %v", err)
+		}
+		l.page = page
+		l.done = len(page) < reconcilePageSize
+		if len(page) > 0 {
+			l.last = page[len(page)-1]
+		}
+	}
+	if len(l.page) == 0 {
+		return nil, nil
+	}
+	path := l.page[0]
+	l.page = l.page[1:]
+	return &path, nil
+}
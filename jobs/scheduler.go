@@ -0,0 +1,290 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+)
+
+// Job is a named task the scheduler runs every Interval, plus a random delay
+// of up to Jitter so replicas and jobs don't all fire at the same moment.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Jitter   time.Duration
+
+	// SkipEmptyRuns keeps frequent polling jobs out of the run history when
+	// they had nothing to do and didn't fail.
+	SkipEmptyRuns bool
+
+	// Run does the work and reports how many items it processed.
+	Run func(ctx context.Context) (int, error)
+}
+
+var (
+	ErrUnknownJob = errors.New("unknown job")
+	ErrJobRunning = errors.New("job is already running")
+)
+
+const (
+	// leaderLockKey is the advisory lock held by the one instance that runs
+	// scheduled jobs.
+	leaderLockKey = int64(0x66696c6573686172) // "fileshar"
+
+	leaderRetryInterval = 15 * time.Second
+	leaderCheckInterval = 10 * time.Second
+)
+
+// Scheduler runs registered jobs on the elected leader instance and records
+// each run in job_runs.
+type Scheduler struct {
+	mu       sync.Mutex
+	jobs     map[string]*Job
+	lastRun  map[string]time.Time
+	instance string
+}
+
+func NewScheduler() *Scheduler {
+	instance, _ := os.Hostname()
+	return &Scheduler{
+		jobs:     make(map[string]*Job),
+		lastRun:  make(map[string]time.Time),
+		instance: fmt.Sprintf("%s:%d", instance, os.Getpid()),
+	}
+}
+
+// Register adds a job. It must be called before Start.
+func (s *Scheduler) Register(job *Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.Name] = job
+}
+
+// Jobs returns the registered jobs sorted by name.
+func (s *Scheduler) Jobs() []*Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return jobs
+}
+
+// Start campaigns for leadership in the background. Only the leader runs
+// scheduled jobs; the others wait and take over if it goes away.
+func (s *Scheduler) Start(ctx context.Context) {
+	go s.campaign(ctx)
+	log.Printf("Scheduler started on %s with %d jobs", s.instance, len(s.Jobs()))
+}
+
+// RunNow runs a job immediately on this instance, outside its schedule. It
+// fails with ErrJobRunning if any instance is running it already.
+func (s *Scheduler) RunNow(ctx context.Context, name string) (*models.JobRun, error) {
+	s.mu.Lock()
+	job, ok := s.jobs[name]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrUnknownJob
+	}
+	return s.execute(ctx, job, "manual")
+}
+
+func (s *Scheduler) campaign(ctx context.Context) {
+	for ctx.Err() == nil {
+		conn, err := tryAdvisoryLock(ctx, leaderLockKey)
+		if err != nil {
+			if err != ErrJobRunning {
+				log.Printf("Scheduler leader election failed: %v", err)
+			}
+			sleepCtx(ctx, leaderRetryInterval)
+			continue
+		}
+
+		log.Printf("👑 %s is now the scheduler leader", s.instance)
+		leaderCtx, cancel := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		for _, job := range s.Jobs() {
+			wg.Add(1)
+			go func(job *Job) {
+				defer wg.Done()
+				s.loop(leaderCtx, job)
+			}(job)
+		}
+
+		// The lock lives as long as its connection, so losing the connection
+		// means losing leadership.
+		for leaderCtx.Err() == nil {
+			sleepCtx(leaderCtx, leaderCheckInterval)
+			if err := conn.PingContext(leaderCtx); err != nil && leaderCtx.Err() == nil {
+				log.Printf("Scheduler lost its leader connection: %v", err)
+				break
+			}
+		}
+
+		cancel()
+		wg.Wait()
+		releaseAdvisoryLock(conn, leaderLockKey)
+		log.Printf("%s stepped down as scheduler leader", s.instance)
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, job *Job) {
+	for {
+		wait := s.untilDue(job)
+		if job.Jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(job.Jitter)))
+		}
+		if !sleepCtx(ctx, wait) {
+			return
+		}
+
+		if _, err := s.execute(ctx, job, "schedule"); err != nil && err != ErrJobRunning {
+			log.Printf("Job %s failed: %v", job.Name, err)
+		}
+	}
+}
+
+// untilDue is how long until job should next run, based on its last run
+// anywhere, so a new leader doesn't rerun jobs the old one just ran.
+func (s *Scheduler) untilDue(job *Job) time.Duration {
+	s.mu.Lock()
+	last := s.lastRun[job.Name]
+	s.mu.Unlock()
+
+	var recorded models.JobRun
+	if err := initializers.DB.
+		Where("job_name = ?", job.Name).
+		Order("started_at DESC").
+		Limit(1).
+		Find(&recorded).Error; err == nil && recorded.StartedAt.After(last) {
+		last = recorded.StartedAt
+	}
+
+	if wait := time.Until(last.Add(job.Interval)); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+func (s *Scheduler) execute(ctx context.Context, job *Job, trigger string) (*models.JobRun, error) {
+	conn, err := tryAdvisoryLock(ctx, jobLockKey(job.Name))
+	if err != nil {
+		return nil, err
+	}
+	defer releaseAdvisoryLock(conn, jobLockKey(job.Name))
+
+	run := models.JobRun{
+		JobName:   job.Name,
+		Trigger:   trigger,
+		Instance:  s.instance,
+		Status:    models.JobRunRunning,
+		StartedAt: time.Now(),
+	}
+	if !job.SkipEmptyRuns {
+		if err := initializers.DB.Create(&run).Error; err != nil {
+			log.Printf("Failed to record start of job %s: %v", job.Name, err)
+		}
+	}
+
+	s.mu.Lock()
+	s.lastRun[job.Name] = run.StartedAt
+	s.mu.Unlock()
+
+	items, runErr := runJob(ctx, job)
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.ItemsProcessed = items
+	run.Status = models.JobRunSucceeded
+	if runErr != nil {
+		run.Status = models.JobRunFailed
+		run.Error = runErr.Error()
+	}
+
+	if !job.SkipEmptyRuns || items > 0 || runErr != nil {
+		if err := initializers.DB.Save(&run).Error; err != nil {
+			log.Printf("Failed to record run of job %s: %v", job.Name, err)
+		}
+	}
+
+	return &run, runErr
+}
+
+// runJob calls job.Run, turning a panic into an error so one bad run
+// doesn't take down the scheduler.
+func runJob(ctx context.Context, job *Job) (items int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.Run(ctx)
+}
+
+func jobLockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("job:" + name))
+	return int64(h.Sum64())
+}
+
+// tryAdvisoryLock takes a session advisory lock on a dedicated connection,
+// which must be passed to releaseAdvisoryLock. It returns ErrJobRunning if
+// the lock is held elsewhere.
+func tryAdvisoryLock(ctx context.Context, key int64) (*sql.Conn, error) {
+	sqlDB, err := initializers.DB.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !locked {
+		conn.Close()
+		return nil, ErrJobRunning
+	}
+	return conn, nil
+}
+
+func releaseAdvisoryLock(conn *sql.Conn, key int64) {
+	if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key); err != nil {
+		log.Printf("Failed to release advisory lock %d: %v", key, err)
+		// Discard the connection rather than return it to the pool still
+		// holding the lock.
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}
+	conn.Close()
+}
+
+// sleepCtx waits for d and reports false if ctx was cancelled first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
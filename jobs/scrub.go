@@ -0,0 +1,97 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/basit/fileshare-backend/config"
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/integrity"
+	"github.com/basit/fileshare-backend/models"
+)
+
+// scrubSampleSize is how many objects one scrub re-reads. Set with
+// SCRUB_SAMPLE_SIZE (default 50). Files checked longest ago go first, so
+// successive runs work through the whole bucket.
+var scrubSampleSize = config.Int("SCRUB_SAMPLE_SIZE", 50)
+
+// scrubFiles re-reads a sample of objects and compares them with the
+// checksums stored at upload, flagging any that don't match.
+func scrubFiles(ctx context.Context) (int, error) {
+	var files []models.File
+	if err := initializers.DB.
+		Where("integrity_status IS NULL OR integrity_status = ?", models.IntegrityOK).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Order("integrity_checked_at ASC NULLS FIRST").
+		Limit(scrubSampleSize).
+		Find(&files).Error; err != nil {
+		return 0, fmt.Errorf("error selecting files to scrub: %v", err)
+	}
+
+	checked, flagged := 0, 0
+	for i := range files {
+		if ctx.Err() != nil {
+			return checked, ctx.Err()
+		}
+		ok, err := scrubFile(ctx, &files[i])
+		if err != nil {
+			log.Printf("Error scrubbing file %s: %v", files[i].ID, err)
+			continue
+		}
+		checked++
+		if !ok {
+			flagged++
+		}
+	}
+
+	if flagged > 0 {
+		log.Printf("Integrity scrub checked %d files, flagged %d", checked, flagged)
+		return checked, fmt.Errorf("%d files failed integrity checks", flagged)
+	}
+	return checked, nil
+}
+
+// scrubFile checks one object. It reports false when the file was flagged,
+// and returns an error only when the check itself couldn't be made.
+func scrubFile(ctx context.Context, file *models.File) (bool, error) {
+	out, err := initializers.S3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(getBucketName()),
+		Key:    aws.String(file.StoragePath),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		integrity.Flag(file, models.IntegrityMissing, "object not found in bucket")
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer out.Body.Close()
+
+	verifier := integrity.NewVerifier(out.Body)
+	if _, err := io.Copy(io.Discard, verifier); err != nil {
+		return false, err
+	}
+
+	sum := verifier.Sum()
+	etag := integrity.NormalizeETag(out.ETag)
+	switch {
+	case file.ChecksumSHA256 != "" && sum != file.ChecksumSHA256:
+		integrity.Flag(file, models.IntegrityMismatch, fmt.Sprintf("object hashed to %s, expected %s", sum, file.ChecksumSHA256))
+		return false, nil
+	case file.StorageETag != "" && etag != file.StorageETag:
+		integrity.Flag(file, models.IntegrityMismatch, fmt.Sprintf("object ETag is %s, expected %s", etag, file.StorageETag))
+		return false, nil
+	}
+
+	integrity.MarkVerified(file, sum, etag)
+	return true, nil
+}
@@ -0,0 +1,37 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LogMailer writes messages to the application log and, when Dir is set,
+// to one .eml file per message so links can be clicked during development.
+type LogMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("📧 Email to %s: %s\n%s", msg.To, msg.Subject, msg.TextBody)
+
+	if m.Dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create mail directory: %w", err)
+	}
+
+	name := fmt.Sprintf("%s_%s.eml", time.Now().Format("20060102T150405"), uuid.New().String())
+	if err := os.WriteFile(filepath.Join(m.Dir, name), buildMIME(m.From, msg), 0o644); err != nil {
+		return fmt.Errorf("failed to write email file: %w", err)
+	}
+	return nil
+}
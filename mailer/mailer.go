@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Message is a single outgoing email.
+type Message struct {
+	To       string
+	Subject  string
+	TextBody string
+	HTMLBody string
+}
+
+// Mailer sends transactional email.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewFromEnv builds a Mailer from MAIL_DRIVER ("smtp" or "log").
+// Anything other than "smtp" falls back to the log mailer so local
+// development works without an SMTP server.
+func NewFromEnv() Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "FileShare <no-reply@localhost>"
+	}
+
+	if os.Getenv("MAIL_DRIVER") == "smtp" {
+		port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+		if err != nil {
+			port = 587
+		}
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+	}
+
+	log.Println("⚠️  MAIL_DRIVER is not smtp, emails will be logged instead of sent")
+	return &LogMailer{
+		Dir:  os.Getenv("MAIL_LOG_DIR"),
+		From: from,
+	}
+}
@@ -0,0 +1,104 @@
+package mailer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// VerificationEmail asks a newly registered user to confirm their address.
+func VerificationEmail(to, link string) Message {
+	return Message{
+		To:      to,
+		Subject: "Verify your FileShare email address",
+		TextBody: fmt.Sprintf(
+			"Welcome to FileShare!\n\nPlease confirm your email address by opening the link below:\n\n%s\n\nThe link expires in 24 hours. If you didn't create an account you can ignore this email.\n",
+			link,
+		),
+	}
+}
+
+// PasswordResetEmail carries a single-use password reset link.
+func PasswordResetEmail(to, link string) Message {
+	return Message{
+		To:      to,
+		Subject: "Reset your FileShare password",
+		TextBody: fmt.Sprintf(
+			"Someone requested a password reset for your FileShare account.\n\nOpen the link below to choose a new password:\n\n%s\n\nThe link expires in 1 hour and can only be used once. If you didn't request this you can ignore this email.\n",
+			link,
+		),
+	}
+}
+
+// LockoutEmail tells an owner that repeated failed attempts locked subject
+// (their account or one of their files).
+func LockoutEmail(to, subject, ip string, failures int, lockedUntil time.Time) Message {
+	return Message{
+		To:      to,
+		Subject: "Too many failed attempts on your FileShare account",
+		TextBody: fmt.Sprintf(
+			"We blocked access to %s after %d failed password attempts.\n\nLast attempt from: %s\nLocked until: %s\n\nIf this wasn't you, consider changing your password.\n",
+			subject, failures, ip, lockedUntil.UTC().Format(time.RFC1123),
+		),
+	}
+}
+
+// DigestFile is one line of a download digest.
+type DigestFile struct {
+	Name      string
+	Downloads int
+}
+
+// DownloadDigestEmail summarises downloads of the owner's files since the
+// last digest.
+func DownloadDigestEmail(to string, total int, files []DigestFile, settingsLink string) Message {
+	var lines strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&lines, "  %s: %d download(s)\n", f.Name, f.Downloads)
+	}
+
+	return Message{
+		To:      to,
+		Subject: fmt.Sprintf("Your FileShare files were downloaded %d time(s)", total),
+		TextBody: fmt.Sprintf(
+			"Here's what was downloaded recently:\n\n%s\nYou can turn off download alerts at any time:\n\n%s\n",
+			lines.String(), settingsLink,
+		),
+	}
+}
+
+// ExpiryReminderEmail warns an owner that a file is about to expire and
+// offers a one-click link to keep it longer.
+func ExpiryReminderEmail(to, fileName string, expiresAt time.Time, extendLink string, extension time.Duration) Message {
+	return Message{
+		To:      to,
+		Subject: fmt.Sprintf("%s expires soon", fileName),
+		TextBody: fmt.Sprintf(
+			"Your file %q will expire and be deleted on %s.\n\nTo keep it for another %s, open the link below:\n\n%s\n\nIf you no longer need the file you can ignore this email.\n",
+			fileName, expiresAt.UTC().Format(time.RFC1123), formatExtension(extension), extendLink,
+		),
+	}
+}
+
+// DataExportReadyEmail sends the link to a finished data export.
+func DataExportReadyEmail(to, link string, expiresAt time.Time) Message {
+	return Message{
+		To:      to,
+		Subject: "Your FileShare data export is ready",
+		TextBody: fmt.Sprintf(
+			"The copy of your data you asked for is ready. It contains your profile, file details, download history and your files.\n\nDownload it here:\n\n%s\n\nThe link works until %s, after which the archive is deleted. If you didn't ask for this export, please change your password.\n",
+			link, expiresAt.UTC().Format(time.RFC1123),
+		),
+	}
+}
+
+func formatExtension(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		days := int(d / (24 * time.Hour))
+		if days == 1 {
+			return "1 day"
+		}
+		return fmt.Sprintf("%d days", days)
+	}
+	return d.String()
+}
@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+)
+
+// SMTPMailer delivers messages through an SMTP relay using PLAIN auth.
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	addr := fmt.Sprintf("%s:%d", m.Host, m.Port)
+	if err := smtp.SendMail(addr, auth, envelopeAddress(m.From), []string{msg.To}, buildMIME(m.From, msg)); err != nil {
+		return fmt.Errorf("failed to send email to %s: %w", msg.To, err)
+	}
+	return nil
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/99designs/gqlgen/graphql/handler"
@@ -12,19 +18,46 @@ import (
 	"github.com/99designs/gqlgen/graphql/playground"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 	"github.com/vektah/gqlparser/v2/ast"
 
+	"github.com/basit/fileshare-backend/analytics"
 	"github.com/basit/fileshare-backend/auth/Oauth"
 	"github.com/basit/fileshare-backend/auth/middleware"
 	"github.com/basit/fileshare-backend/graph"
 	"github.com/basit/fileshare-backend/graph/resolvers"
 	"github.com/basit/fileshare-backend/initializers"
 	"github.com/basit/fileshare-backend/jobs"
+	"github.com/basit/fileshare-backend/notifications"
+	"github.com/basit/fileshare-backend/ratelimit"
 	"github.com/basit/fileshare-backend/routes"
+	"github.com/basit/fileshare-backend/webhooks"
 )
 
 const defaultPort = "8080"
 
+var allowedOrigins = []string{
+	"http://localhost:3000",
+	"https://preview--easy-file-glide.lovable.app",
+	"https://*.lovable.app", // This allows all Lovable preview domains
+	"https://basitsfileshare.netlify.app",
+}
+
+// allowedOrigin applies the CORS origin list to websocket upgrades, which
+// the CORS middleware doesn't cover.
+func allowedOrigin(origin string) bool {
+	for _, allowed := range allowedOrigins {
+		if allowed == origin {
+			return true
+		}
+		if prefix, suffix, ok := strings.Cut(allowed, "*"); ok &&
+			strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
 func init() {
 	start := time.Now()
 	log.Println("⏳ Initializing application...")
@@ -34,10 +67,12 @@ func init() {
 	log.Printf("✅ Database connected in %v", time.Since(dbStart))
 
 	initializers.InitAWS()
+	initializers.InitMailer()
+	initializers.InitRateLimiter()
 
 	authStart := time.Now()
 	Oauth.InitStore()
-	log.Printf("✅ Google auth store initialized in %v", time.Since(authStart))
+	log.Printf("✅ OAuth store initialized in %v", time.Since(authStart))
 
 	log.Printf("🚀 Total init() completed in %v", time.Since(start))
 }
@@ -50,8 +85,20 @@ func main() {
 
 	srv := handler.New(graph.NewExecutableSchema(graph.Config{
 		Resolvers: &resolvers.Resolver{},
+		Directives: graph.DirectiveRoot{
+			HasRole: resolvers.HasRoleDirective,
+		},
 	}))
 
+	srv.AddTransport(transport.Websocket{
+		KeepAlivePingInterval: 10 * time.Second,
+		InitFunc:              middleware.WebsocketInit,
+		Upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return allowedOrigin(r.Header.Get("Origin"))
+			},
+		},
+	})
 	srv.AddTransport(transport.Options{})
 	srv.AddTransport(transport.GET{})
 	srv.AddTransport(transport.POST{})
@@ -62,45 +109,78 @@ func main() {
 	srv.Use(extension.AutomaticPersistedQuery{
 		Cache: lru.New[string](100),
 	})
-	// Start cleanup job
-	jobs.StartCleanupJob()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Start background jobs; only the elected leader instance runs them
+	jobs.Start(ctx)
+	notifications.StartListener()
+	analytics.Events.Start()
+	webhooks.Start()
 
 	router := gin.Default()
 	// Add CORS middleware before other middleware
 	router.Use(cors.New(cors.Config{
 		// AllowOrigins:     []string{"http://localhost:3000"},
 		// AllowAllOrigins:  true,
-		AllowOrigins: []string{
-			"http://localhost:3000",
-			"https://preview--easy-file-glide.lovable.app",
-			"https://*.lovable.app", // This allows all Lovable preview domains
-			"https://basitsfileshare.netlify.app",
-		},
+		AllowOrigins:     allowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
-	// Global middleware
-	router.Use(
-		middleware.RateLimitMiddleware(),
-	)
-
 	routes.RegisterFileRoutes(router)
+	routes.RegisterAdminRoutes(router)
 
-	router.GET("/", func(c *gin.Context) {
+	router.GET("/", middleware.RateLimit(ratelimit.PolicyDefault), func(c *gin.Context) {
 		playground.Handler("GraphQL playground", "/query").ServeHTTP(c.Writer, c.Request)
 	})
 
 	router.POST("/graphql",
 		middleware.AuthOptional(),
+		middleware.RateLimit(ratelimit.PolicyGraphQL),
 		middleware.GinContextToContextMiddleware(),
 		func(c *gin.Context) {
 			srv.ServeHTTP(c.Writer, c.Request)
 		},
 	)
 
-	log.Printf("connect to http://localhost:%s/ for GraphQL playground", port)
-	log.Fatal(router.Run(":" + port))
+	// Subscriptions upgrade to a websocket here and authenticate in
+	// WebsocketInit, from the connection_init payload.
+	router.GET("/graphql",
+		middleware.RateLimit(ratelimit.PolicyGraphQL),
+		middleware.GinContextToContextMiddleware(),
+		func(c *gin.Context) {
+			srv.ServeHTTP(c.Writer, c.Request)
+		},
+	)
+
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: router,
+	}
+	go func() {
+		log.Printf("connect to http://localhost:%s/ for GraphQL playground", port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Println("⏳ Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("⚠️  HTTP server shutdown: %v", err)
+	}
+	if err := analytics.Events.Stop(shutdownCtx); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	if err := webhooks.Stop(shutdownCtx); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	log.Println("👋 Shutdown complete")
 }
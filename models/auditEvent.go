@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditEvent records a security-relevant or file lifecycle action. ActorID
+// is nil for actions taken by the system, such as expiry cleanup.
+type AuditEvent struct {
+	ID         uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	ActorID    *uuid.UUID `gorm:"type:uuid;index"`
+	Action     string     `gorm:"index;not null"`
+	TargetType string     `gorm:"index:idx_audit_target"`
+	TargetID   string     `gorm:"index:idx_audit_target"`
+	IPAddress  string
+	UserAgent  string
+	Metadata   string    `gorm:"type:jsonb;default:'{}'"`
+	CreatedAt  time.Time `gorm:"index"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	LockoutKindLogin        = "login"
+	LockoutKindFilePassword = "file_password"
+)
+
+// AuthThrottle counts consecutive failed attempts against one key, such as
+// an account email or a file slug. It lives in Postgres so every instance
+// sees the same counters.
+type AuthThrottle struct {
+	Key           string `gorm:"primaryKey"`
+	Failures      int    `gorm:"not null;default:0"`
+	LockedUntil   *time.Time
+	LastFailureAt time.Time `gorm:"index"`
+}
+
+// LockoutEvent is shown to the owner of the account or file that was locked.
+type LockoutEvent struct {
+	ID          uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID      uuid.UUID `gorm:"type:uuid;index;not null"`
+	Kind        string    `gorm:"not null"`
+	FileID      *uuid.UUID
+	IPAddress   string
+	Failures    int
+	LockedUntil time.Time
+	CreatedAt   time.Time
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DataExportPending = "pending"
+	DataExportRunning = "running"
+	DataExportReady   = "ready"
+	DataExportFailed  = "failed"
+)
+
+// DataExport is a user's request for a copy of their data. When ready,
+// FileID is the archive, stored as a private file that expires.
+type DataExport struct {
+	ID          uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID      uuid.UUID `gorm:"type:uuid;not null;index"`
+	Status      string    `gorm:"not null;index"`
+	Attempts    int       `gorm:"not null;default:0"`
+	FileID      *uuid.UUID
+	Error       string
+	CreatedAt   time.Time
+	StartedAt   *time.Time
+	CompletedAt *time.Time
+	ExpiresAt   *time.Time
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileDailyStat is one file's downloads on one UTC day, rolled up from
+// DownloadEvent.
+type FileDailyStat struct {
+	FileID            uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Day               time.Time `gorm:"type:date;primaryKey;index"`
+	Downloads         int64     `gorm:"not null;default:0"`
+	UniqueDownloaders int64     `gorm:"not null;default:0"`
+}
+
+// FileDailyDownloader records that a downloader fetched a file on a day, so
+// unique downloaders can be counted over any range of days. DownloaderHash
+// is a keyed MD5 of the user ID or, for anonymous downloads, the IP address.
+// Rows are deleted when the day's events are anonymised.
+type FileDailyDownloader struct {
+	FileID         uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Day            time.Time `gorm:"type:date;primaryKey;index"`
+	DownloaderHash string    `gorm:"primaryKey"`
+}
+
+// FileDailyBreakdown counts one file's downloads on a day by a dimension
+// such as browser or referrer.
+type FileDailyBreakdown struct {
+	FileID    uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Day       time.Time `gorm:"type:date;primaryKey;index"`
+	Dimension string    `gorm:"primaryKey"`
+	Value     string    `gorm:"primaryKey"`
+	Count     int64     `gorm:"not null;default:0"`
+}
+
+// RollupState tracks how far a rollup has got. Days before CompleteThrough
+// are final; later days are recomputed on every run.
+type RollupState struct {
+	Name            string    `gorm:"primaryKey"`
+	CompleteThrough time.Time `gorm:"type:date"`
+	UpdatedAt       time.Time
+}
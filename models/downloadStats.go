@@ -9,10 +9,24 @@ import (
 
 type DownloadEvent struct {
 	ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
-	FileID    uuid.UUID
-	File      File `gorm:"foreignKey:FileID"`
+	FileID    uuid.UUID `gorm:"index:idx_download_event_file_created"`
+	File      File      `gorm:"foreignKey:FileID"`
 	IPAddress string
 	UserAgent string
+	Referrer  string `gorm:"default:null"`
 	UserID    *uuid.UUID
-	CreatedAt time.Time
+	CreatedAt time.Time `gorm:"index:idx_download_event_file_created;index"`
+
+	// Filled in at ingest from a GeoIP database and the user agent. Events
+	// recorded before enrichment have these empty.
+	Country string `gorm:"size:2;default:null"`
+	City    string `gorm:"default:null"`
+	Browser string `gorm:"default:null"`
+	OS      string `gorm:"column:os;default:null"`
+	Device  string `gorm:"default:null"`
+	IsBot   bool   `gorm:"not null;default:false"`
+
+	// AnonymizedAt is set once identifying details have been removed,
+	// either at ingest for owners who opted out or by the anonymise job.
+	AnonymizedAt *time.Time
 }
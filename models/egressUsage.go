@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EgressUsage is the number of bytes served from one owner's files in a
+// calendar month (UTC). Month is always the first day of that month.
+type EgressUsage struct {
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Month     time.Time `gorm:"type:date;primaryKey"`
+	Bytes     int64     `gorm:"not null;default:0"`
+	UpdatedAt time.Time
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ExpiryReminder records that the owner was reminded about a file expiring
+// within a window. ExpiresAt is part of the key, so extending a file makes
+// its reminders due again for the new expiry.
+type ExpiryReminder struct {
+	FileID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	WindowSeconds int64     `gorm:"primaryKey;autoIncrement:false"`
+	ExpiresAt     time.Time `gorm:"primaryKey;index"`
+	SentAt        time.Time `gorm:"not null"`
+}
@@ -4,9 +4,28 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
 
+const (
+	IntegrityOK       = "ok"
+	IntegrityMismatch = "mismatch"
+	IntegrityMissing  = "missing"
 )
 
+// FileKindDataExport marks a data export archive built for its owner.
+const FileKindDataExport = "data_export"
+
+// UploadCondition is the SQL condition matching files users uploaded,
+// leaving out those the system creates, such as data export archives.
+// Those are only reached through their own endpoints.
+const UploadCondition = "files.kind IS NULL"
+
+// Uploads limits a files query to UploadCondition.
+func Uploads(db *gorm.DB) *gorm.DB {
+	return db.Where(UploadCondition)
+}
+
 type File struct {
 	ID           uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
 	OriginalName string
@@ -14,7 +33,7 @@ type File struct {
 	FileSize     int32
 	DownloadSlug string    `gorm:"uniqueIndex"`
 	CreatedAt    time.Time `gorm:"autoCreateTime"`
-	ExpiresAt    *time.Time
+	ExpiresAt    *time.Time `gorm:"index"`
 	PublicURL    string `gorm:"default:null;text;"`
 	ContentType  string
 	IsPublic     bool `gorm:"default:true"`
@@ -27,4 +46,20 @@ type File struct {
 	LastDownloadedAt *time.Time
 
 	QRCodePath string `gorm:"default:null"`
+
+	// Set by the expiry cleanup when the object can't be deleted, so the
+	// file backs off instead of being retried every run.
+	CleanupFailures      int `gorm:"not null;default:0"`
+	CleanupLastError     string
+	CleanupNextAttemptAt *time.Time
+
+	// Recorded at upload and checked on download and by the nightly scrub.
+	ChecksumSHA256     string `gorm:"default:null"`
+	StorageETag        string `gorm:"default:null"`
+	IntegrityStatus    string `gorm:"default:null;index"`
+	IntegrityCheckedAt *time.Time
+	IntegrityDetail    string `gorm:"default:null"`
+
+	// Kind is empty for uploads and set for files the system creates.
+	Kind string `gorm:"default:null"`
 }
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// IPHashSalt is the secret mixed into hashed download IPs for one rotation
+// period. Deleting a period's salt makes its hashes unlinkable.
+type IPHashSalt struct {
+	PeriodStart time.Time `gorm:"primaryKey"`
+	Salt        []byte    `gorm:"not null"`
+	CreatedAt   time.Time
+}
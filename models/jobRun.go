@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	JobRunRunning   = "running"
+	JobRunSucceeded = "succeeded"
+	JobRunFailed    = "failed"
+)
+
+// JobRun is one execution of a scheduled background job.
+type JobRun struct {
+	ID             uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	JobName        string    `gorm:"not null;index:idx_job_run_name_started"`
+	Trigger        string    `gorm:"not null"` // "schedule" or "manual"
+	Instance       string
+	Status         string    `gorm:"not null"`
+	StartedAt      time.Time `gorm:"not null;index:idx_job_run_name_started"`
+	FinishedAt     *time.Time
+	ItemsProcessed int
+	Error          string
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	NotificationDownloadDigest  = "download_digest"
+	NotificationDataExportReady = "data_export_ready"
+)
+
+// Notification is an in-app message for one user. Data holds type-specific
+// JSON, such as the files included in a download digest.
+type Notification struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index:idx_notification_user_created"`
+	Type      string    `gorm:"not null"`
+	Title     string    `gorm:"not null"`
+	Body      string
+	Data      string `gorm:"type:jsonb;default:'{}'"`
+	ReadAt    *time.Time
+	CreatedAt time.Time `gorm:"index:idx_notification_user_created"`
+}
+
+// PendingDownloadAlert is a download waiting to be included in the owner's
+// next digest.
+type PendingDownloadAlert struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	OwnerID   uuid.UUID `gorm:"type:uuid;not null;index"`
+	FileID    uuid.UUID `gorm:"type:uuid;not null"`
+	FileName  string
+	CreatedAt time.Time `gorm:"index"`
+
+	// ClaimedAt is set while a digest run is sending this alert. The row is
+	// deleted once the digest is sent, so a crashed run's claims expire and
+	// are picked up again.
+	ClaimedAt *time.Time `gorm:"index"`
+}
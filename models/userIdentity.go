@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+
+	_ "github.com/basit/fileshare-backend/encryption" // registers the "encrypted" serializer
+)
+
+// UserIdentity is an external login (Google, GitHub, OpenID Connect) linked
+// to a user. A user may have any number of identities.
+type UserIdentity struct {
+	ID             uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID         uuid.UUID `gorm:"type:uuid;index;not null"`
+	User           User      `gorm:"foreignKey:UserID" json:"-"`
+	Provider       string    `gorm:"uniqueIndex:idx_identity_provider_user;not null"`
+	ProviderUserID string    `gorm:"uniqueIndex:idx_identity_provider_user;not null"`
+	Email          string
+
+	// Provider tokens are encrypted at rest with TOKEN_ENCRYPTION_KEYS.
+	AccessToken    *string    `gorm:"serializer:encrypted" json:"-"` // Don't expose in JSON
+	RefreshToken   *string    `gorm:"serializer:encrypted" json:"-"`
+	TokenExpiresAt *time.Time `json:"-"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
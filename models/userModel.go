@@ -7,6 +7,11 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
 	ID              uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
 	Email           string    `gorm:"unique;not null"`
@@ -15,21 +20,34 @@ type User struct {
 	UpdatedAt       time.Time
 	DownloadAlerts  bool `gorm:"default:true"`
 	ExpiryReminders bool `gorm:"default:true"`
+	EmailVerified   bool `gorm:"default:false"`
+	EmailVerifiedAt *time.Time
+	Role            string `gorm:"not null;default:user;index"`
+	SuspendedAt     *time.Time
+
+	// PasswordChangedAt is when the password was last reset. Session
+	// tokens issued before then are rejected.
+	PasswordChangedAt *time.Time
 
-	GoogleID           *string `gorm:"uniqueIndex" json:"google_id,omitempty"`
-	GitHubID           *string `gorm:"uniqueIndex" json:"github_id,omitempty"`
-	GoogleAccessToken  *string `json:"-"` // Don't expose in JSON
-	GoogleRefreshToken *string `json:"-"`
-	GitHubAccessToken  *string `json:"-"`
-	Provider           *string `json:"provider,omitempty"`
+	// Provider is the login method the account was created with.
+	Provider *string `json:"provider,omitempty"`
 
-	GoogleTokenExpiresAt *time.Time `json:"-"`
-	GitHubTokenExpiresAt *time.Time `json:"-"`
+	// DetailedDownloadLogs keeps downloaders' IPs and user agents on events
+	// for this user's files. When off, only counts and coarse breakdowns
+	// are stored.
+	DetailedDownloadLogs bool `gorm:"default:true"`
+}
+
+func (u *User) IsSuspended() bool {
+	return u.SuspendedAt != nil
 }
 
 func (u *User) BeforeCreate(tx *gorm.DB) error {
 	if u.ID == uuid.Nil {
 		u.ID = uuid.New()
 	}
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
 	return nil
 }
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+
+	_ "github.com/basit/fileshare-backend/encryption" // registers the "encrypted" serializer
+)
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// Webhook is an endpoint a user registered to receive file events. Events
+// lists the event names it subscribes to.
+type Webhook struct {
+	ID     uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID uuid.UUID `gorm:"type:uuid;index;not null"`
+	URL    string    `gorm:"not null"`
+	Events []string  `gorm:"serializer:json;type:jsonb;not null"`
+	Active bool      `gorm:"not null;default:true"`
+
+	// Secret signs payloads and is encrypted at rest.
+	Secret string `gorm:"serializer:encrypted;not null" json:"-"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// WebhookDelivery is one event queued for one webhook, and doubles as the
+// delivery log: it keeps the attempt count and the outcome of the latest
+// attempt.
+type WebhookDelivery struct {
+	ID             uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	WebhookID      uuid.UUID `gorm:"type:uuid;not null;index:idx_webhook_delivery_webhook"`
+	Event          string    `gorm:"not null"`
+	Payload        string    `gorm:"type:jsonb;not null"`
+	Status         string    `gorm:"not null;default:pending;index:idx_webhook_delivery_due"`
+	Attempts       int       `gorm:"not null;default:0"`
+	NextAttemptAt  time.Time `gorm:"not null;index:idx_webhook_delivery_due"`
+	LastAttemptAt  *time.Time
+	ResponseStatus *int
+	ResponseBody   string
+	LastError      string
+	DeliveredAt    *time.Time
+	CreatedAt      time.Time `gorm:"index:idx_webhook_delivery_webhook"`
+}
@@ -0,0 +1,138 @@
+package notifications
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+)
+
+// pgChannel is the Postgres NOTIFY channel new notification IDs are sent on,
+// so subscribers connected to any instance hear about them.
+const pgChannel = "notifications"
+
+// subscriberBuffer is how many notifications a slow subscriber may fall
+// behind before further ones are dropped for it.
+const subscriberBuffer = 16
+
+type hub struct {
+	mu   sync.RWMutex
+	subs map[uuid.UUID]map[chan *models.Notification]struct{}
+}
+
+var (
+	local     = &hub{subs: make(map[uuid.UUID]map[chan *models.Notification]struct{})}
+	listening atomic.Bool
+)
+
+// Subscribe returns a channel that receives userID's new notifications until
+// cancel is called.
+func Subscribe(userID uuid.UUID) (<-chan *models.Notification, func()) {
+	ch := make(chan *models.Notification, subscriberBuffer)
+
+	local.mu.Lock()
+	if local.subs[userID] == nil {
+		local.subs[userID] = make(map[chan *models.Notification]struct{})
+	}
+	local.subs[userID][ch] = struct{}{}
+	local.mu.Unlock()
+
+	cancel := func() {
+		local.mu.Lock()
+		delete(local.subs[userID], ch)
+		if len(local.subs[userID]) == 0 {
+			delete(local.subs, userID)
+		}
+		local.mu.Unlock()
+	}
+	return ch, cancel
+}
+
+func (h *hub) deliver(n *models.Notification) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for ch := range h.subs[n.UserID] {
+		select {
+		case ch <- n:
+		default:
+			log.Printf("Dropping notification %s for slow subscriber of user %s", n.ID, n.UserID)
+		}
+	}
+}
+
+// publish fans n out to subscribers on every instance. Without a running
+// listener it can only reach subscribers on this one.
+func publish(n *models.Notification) {
+	if listening.Load() {
+		err := initializers.DB.Exec("SELECT pg_notify(?, ?)", pgChannel, n.ID.String()).Error
+		if err == nil {
+			return
+		}
+		log.Printf("Failed to publish notification %s: %v", n.ID, err)
+	}
+	local.deliver(n)
+}
+
+// StartListener relays notifications published by any instance to this
+// instance's subscribers, reconnecting if the connection drops.
+func StartListener() {
+	dsn := os.Getenv("DB_URL")
+	if dsn == "" {
+		log.Println("⚠️  DB_URL is not set, notifications only reach subscribers on this instance")
+		return
+	}
+
+	go func() {
+		backoff := time.Second
+		for {
+			err := listen(context.Background(), dsn)
+			listening.Store(false)
+			log.Printf("Notification listener stopped: %v (retrying in %v)", err, backoff)
+			time.Sleep(backoff)
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+		}
+	}()
+	log.Println("Notification listener started")
+}
+
+func listen(ctx context.Context, dsn string) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgChannel); err != nil {
+		return err
+	}
+	listening.Store(true)
+
+	for {
+		msg, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		id, err := uuid.Parse(msg.Payload)
+		if err != nil {
+			continue
+		}
+		var n models.Notification
+		if err := initializers.DB.First(&n, "id = ?", id).Error; err != nil {
+			log.Printf("Failed to load notification %s: %v", id, err)
+			continue
+		}
+		local.deliver(&n)
+	}
+}
@@ -0,0 +1,69 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+)
+
+// Create stores an in-app notification for userID. data is marshalled to
+// JSON and may be nil.
+func Create(userID uuid.UUID, kind, title, body string, data map[string]interface{}) (*models.Notification, error) {
+	payload := "{}"
+	if len(data) > 0 {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode notification data: %v", err)
+		}
+		payload = string(b)
+	}
+
+	n := models.Notification{
+		ID:     uuid.New(),
+		UserID: userID,
+		Type:   kind,
+		Title:  title,
+		Body:   body,
+		Data:   payload,
+	}
+	if err := initializers.DB.Create(&n).Error; err != nil {
+		return nil, fmt.Errorf("failed to create notification: %v", err)
+	}
+
+	publish(&n)
+	return &n, nil
+}
+
+// EnqueueDownloadAlert queues a download of file for the owner's next
+// digest, if the owner has download alerts turned on. Owners downloading
+// their own files are not alerted.
+func EnqueueDownloadAlert(file *models.File, downloaderID *uuid.UUID) {
+	if file.UserID == nil {
+		return
+	}
+	if downloaderID != nil && *downloaderID == *file.UserID {
+		return
+	}
+
+	var owner models.User
+	if err := initializers.DB.Select("id", "download_alerts").First(&owner, "id = ?", file.UserID).Error; err != nil {
+		return
+	}
+	if !owner.DownloadAlerts {
+		return
+	}
+
+	alert := models.PendingDownloadAlert{
+		OwnerID:  owner.ID,
+		FileID:   file.ID,
+		FileName: file.OriginalName,
+	}
+	if err := initializers.DB.Create(&alert).Error; err != nil {
+		log.Printf("Failed to queue download alert for file %s: %v", file.ID, err)
+	}
+}
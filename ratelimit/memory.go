@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// MemoryLimiter keeps buckets in process memory. Limits are per instance
+// and reset on restart.
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*clientLimiter
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	m := &MemoryLimiter{clients: make(map[string]*clientLimiter)}
+	go m.cleanupClients()
+	return m
+}
+
+func (m *MemoryLimiter) cleanupClients() {
+	for {
+		time.Sleep(time.Minute)
+		m.mu.Lock()
+		for key, c := range m.clients {
+			if time.Since(c.lastSeen) > 3*time.Minute {
+				delete(m.clients, key)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+func (m *MemoryLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cl, exists := m.clients[key]
+	if !exists {
+		cl = &clientLimiter{limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)}
+		m.clients[key] = cl
+	}
+	cl.lastSeen = time.Now()
+
+	allowed := cl.limiter.Allow()
+	return result(limit, cl.limiter.Tokens(), allowed), nil
+}
@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	PolicyDefault  = "default"
+	PolicyLogin    = "login"
+	PolicyUpload   = "upload"
+	PolicyDownload = "download"
+	PolicyGraphQL  = "graphql"
+)
+
+// Policy is the budget for one route group. Authenticated callers are
+// limited per user, anonymous callers per IP.
+type Policy struct {
+	Name          string
+	Authenticated Limit
+	Anonymous     Limit
+}
+
+func (p Policy) LimitFor(authenticated bool) Limit {
+	if authenticated {
+		return p.Authenticated
+	}
+	return p.Anonymous
+}
+
+var defaultPolicies = map[string]Policy{
+	PolicyDefault: {
+		Authenticated: Limit{Rate: 5, Burst: 20},
+		Anonymous:     Limit{Rate: 1, Burst: 5},
+	},
+	// Credential endpoints: a handful of attempts per minute.
+	PolicyLogin: {
+		Authenticated: Limit{Rate: 5.0 / 60, Burst: 5},
+		Anonymous:     Limit{Rate: 5.0 / 60, Burst: 5},
+	},
+	PolicyUpload: {
+		Authenticated: Limit{Rate: 30.0 / 60, Burst: 10},
+		Anonymous:     Limit{Rate: 30.0 / 60, Burst: 10},
+	},
+	PolicyDownload: {
+		Authenticated: Limit{Rate: 5, Burst: 30},
+		Anonymous:     Limit{Rate: 2, Burst: 10},
+	},
+	// The dashboard fires several queries per page view.
+	PolicyGraphQL: {
+		Authenticated: Limit{Rate: 10, Burst: 60},
+		Anonymous:     Limit{Rate: 2, Burst: 20},
+	},
+}
+
+var (
+	policies     map[string]Policy
+	policiesOnce sync.Once
+)
+
+// PolicyFor returns the named policy. Defaults can be overridden with
+// RATE_LIMIT_<NAME>=rate:burst for authenticated callers and
+// RATE_LIMIT_<NAME>_ANON=rate:burst for anonymous ones, where rate is in
+// requests per second.
+func PolicyFor(name string) Policy {
+	policiesOnce.Do(loadPolicies)
+	if p, ok := policies[name]; ok {
+		return p
+	}
+	return policies[PolicyDefault]
+}
+
+func loadPolicies() {
+	policies = make(map[string]Policy, len(defaultPolicies))
+	for name, p := range defaultPolicies {
+		p.Name = name
+		envName := "RATE_LIMIT_" + strings.ToUpper(name)
+		if limit, ok := parseLimitEnv(envName); ok {
+			p.Authenticated = limit
+		}
+		if limit, ok := parseLimitEnv(envName + "_ANON"); ok {
+			p.Anonymous = limit
+		}
+		policies[name] = p
+	}
+}
+
+func parseLimitEnv(key string) (Limit, bool) {
+	value := os.Getenv(key)
+	if value == "" {
+		return Limit{}, false
+	}
+
+	rateStr, burstStr, ok := strings.Cut(value, ":")
+	if !ok {
+		log.Printf("⚠️  Ignoring %s=%q, expected rate:burst", key, value)
+		return Limit{}, false
+	}
+	rate, err := strconv.ParseFloat(rateStr, 64)
+	if err != nil || rate <= 0 {
+		log.Printf("⚠️  Ignoring %s=%q, invalid rate", key, value)
+		return Limit{}, false
+	}
+	burst, err := strconv.Atoi(burstStr)
+	if err != nil || burst <= 0 {
+		log.Printf("⚠️  Ignoring %s=%q, invalid burst", key, value)
+		return Limit{}, false
+	}
+	return Limit{Rate: rate, Burst: burst}, true
+}
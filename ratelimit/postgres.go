@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// bucket is one token bucket row shared by every instance.
+type bucket struct {
+	Key         string `gorm:"primaryKey"`
+	Tokens      float64
+	LastAllowed bool
+	UpdatedAt   time.Time `gorm:"index"`
+}
+
+func (bucket) TableName() string {
+	return "rate_limit_buckets"
+}
+
+// PostgresLimiter stores buckets in Postgres so limits hold across replicas
+// and restarts. Each check is a single upsert that refills and takes a token
+// atomically using the database clock.
+type PostgresLimiter struct {
+	db *gorm.DB
+}
+
+func NewPostgresLimiter(db *gorm.DB) *PostgresLimiter {
+	if err := db.AutoMigrate(&bucket{}); err != nil {
+		log.Fatalf("❌ Failed to migrate rate limit buckets: %v", err)
+	}
+	p := &PostgresLimiter{db: db}
+	go p.cleanupBuckets()
+	return p
+}
+
+func (p *PostgresLimiter) cleanupBuckets() {
+	for {
+		time.Sleep(time.Minute)
+		// A bucket idle this long has refilled completely, so dropping it
+		// doesn't change any outcome.
+		if err := p.db.Where("updated_at < NOW() - INTERVAL '1 hour'").Delete(&bucket{}).Error; err != nil {
+			log.Printf("Failed to clean up rate limit buckets: %v", err)
+		}
+	}
+}
+
+// refilled is the bucket's token count after refilling for the time since
+// its last update. Every reference to b in the SET list sees the locked,
+// pre-update row, so concurrent requests for one key are serialised.
+const refilled = `LEAST(@burst::float8, b.tokens + EXTRACT(EPOCH FROM (NOW() - b.updated_at)) * @rate::float8)`
+
+var takeTokenSQL = `
+INSERT INTO rate_limit_buckets AS b (key, tokens, last_allowed, updated_at)
+VALUES (@key, @burst::float8 - 1, TRUE, NOW())
+ON CONFLICT (key) DO UPDATE SET
+	tokens = ` + refilled + ` - CASE WHEN ` + refilled + ` >= 1 THEN 1 ELSE 0 END,
+	last_allowed = ` + refilled + ` >= 1,
+	updated_at = NOW()
+RETURNING tokens, last_allowed`
+
+func (p *PostgresLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	var row struct {
+		Tokens      float64
+		LastAllowed bool
+	}
+	if err := p.db.WithContext(ctx).
+		Raw(takeTokenSQL, map[string]interface{}{
+			"key":   key,
+			"burst": float64(limit.Burst),
+			"rate":  limit.Rate,
+		}).
+		Scan(&row).Error; err != nil {
+		return Result{}, err
+	}
+	return result(limit, row.Tokens, row.LastAllowed), nil
+}
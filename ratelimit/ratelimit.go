@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Limit is a token bucket: Rate tokens are added per second up to Burst.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result describes the outcome of one Allow call.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until the next request would be allowed. It is
+	// zero when Allowed is true and tokens remain.
+	RetryAfter time.Duration
+	// ResetAfter is how long until the bucket is full again.
+	ResetAfter time.Duration
+}
+
+// RateLimiter takes one token from the bucket identified by key.
+type RateLimiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// NewFromEnv picks the store from RATE_LIMIT_STORE: "postgres" shares
+// buckets between instances through the database, anything else keeps them
+// in process memory.
+func NewFromEnv(db *gorm.DB) RateLimiter {
+	if os.Getenv("RATE_LIMIT_STORE") == "postgres" {
+		log.Println("Rate limiting backed by Postgres")
+		return NewPostgresLimiter(db)
+	}
+	return NewMemoryLimiter()
+}
+
+func result(limit Limit, tokens float64, allowed bool) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     limit.Burst,
+		Remaining: int(tokens),
+	}
+	if res.Remaining < 0 {
+		res.Remaining = 0
+	}
+	if limit.Rate > 0 {
+		if tokens < 1 {
+			res.RetryAfter = time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+		}
+		if missing := float64(limit.Burst) - tokens; missing > 0 {
+			res.ResetAfter = time.Duration(missing / limit.Rate * float64(time.Second))
+		}
+	}
+	return res
+}
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/basit/fileshare-backend/auth/middleware"
+	"github.com/basit/fileshare-backend/handlers"
+	"github.com/basit/fileshare-backend/models"
+	"github.com/basit/fileshare-backend/ratelimit"
+)
+
+func RegisterAdminRoutes(r *gin.Engine) {
+	adminGroup := r.Group("/api/admin")
+	adminGroup.Use(
+		middleware.AuthRequired(),
+		middleware.RequireRole(models.RoleAdmin),
+		middleware.RateLimit(ratelimit.PolicyDefault),
+	)
+	{
+		adminGroup.POST("/cleanup", handlers.RunCleanup)
+		adminGroup.POST("/reconcile", handlers.ReconcileStorage)
+	}
+}
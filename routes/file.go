@@ -6,23 +6,42 @@ import (
 	"github.com/basit/fileshare-backend/auth/Oauth"
 	"github.com/basit/fileshare-backend/auth/middleware"
 	"github.com/basit/fileshare-backend/handlers"
+	"github.com/basit/fileshare-backend/ratelimit"
 )
 
 func RegisterFileRoutes(r *gin.Engine) {
+	r.GET("/auth/:provider", middleware.RateLimit(ratelimit.PolicyLogin), Oauth.OauthCallbackHandler)
+	r.GET("/auth/:provider/callback", middleware.RateLimit(ratelimit.PolicyLogin), Oauth.CompleteAuth)
+
 	// Public download route (no auth required)
-	r.GET("/auth/:provider", Oauth.OauthCallbackHandler)
-	r.GET("/auth/:provider/callback", Oauth.CompleteAuth)
-	r.GET("/api/files/download/:slug", handlers.DownloadFile)
-	r.GET("/d/:slug", handlers.HandlePublicDownload)
+	downloadLimit := middleware.RateLimit(ratelimit.PolicyDownload)
+	r.GET("/api/files/download/:slug", middleware.AuthOptional(), downloadLimit, handlers.DownloadFile)
+	r.GET("/d/:slug", downloadLimit, handlers.HandlePublicDownload)
+
+	// One-click expiry extension from reminder emails (the signed token is the auth)
+	r.GET("/api/files/extend/:token", middleware.RateLimit(ratelimit.PolicyDefault), handlers.ConfirmExtendFileExpiry)
+	r.POST("/api/files/extend/:token", middleware.RateLimit(ratelimit.PolicyDefault), handlers.ExtendFileExpiry)
 
 	// Protected file management routes (auth required)
+	defaultLimit := middleware.RateLimit(ratelimit.PolicyDefault)
 	fileGroup := r.Group("/api/files")
 	fileGroup.Use(middleware.AuthRequired())
 	{
-		fileGroup.POST("/upload", handlers.UploadFile)
-		fileGroup.GET("/", handlers.ListFiles)
-		fileGroup.PUT("/:id/rename", handlers.RenameFile)
-		fileGroup.DELETE("/:id", handlers.DeleteFile)
-		fileGroup.GET("/:slug/qr", handlers.GetQRCode)
+		fileGroup.POST("/upload", middleware.RateLimit(ratelimit.PolicyUpload), handlers.UploadFile)
+		fileGroup.GET("/", defaultLimit, handlers.ListFiles)
+		fileGroup.PUT("/:id/rename", defaultLimit, handlers.RenameFile)
+		fileGroup.PUT("/:id/sharing", defaultLimit, handlers.UpdateShareSettings)
+		fileGroup.DELETE("/:id", defaultLimit, handlers.DeleteFile)
+		fileGroup.GET("/:slug/qr", defaultLimit, handlers.GetQRCode)
+	}
+
+	// Streaming CSV/NDJSON exports of the caller's files and download history
+	exportGroup := r.Group("/api/exports")
+	exportGroup.Use(middleware.AuthRequired(), defaultLimit)
+	{
+		exportGroup.GET("/files", handlers.ExportFiles)
+		exportGroup.GET("/downloads", handlers.ExportDownloadEvents)
 	}
+	// Data export archives, linked from the ready email (the signed token is the auth)
+	r.GET("/api/exports/takeout/:token", defaultLimit, handlers.DownloadDataExport)
 }
@@ -0,0 +1,51 @@
+package throttle
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// MonthStart returns the first day of t's month in UTC, the key egress usage
+// is bucketed under.
+func MonthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// RecordEgress adds bytes to the owner's usage for the current month.
+func RecordEgress(db *gorm.DB, ownerID uuid.UUID, bytes int64) error {
+	if bytes <= 0 {
+		return nil
+	}
+	return db.Exec(`
+INSERT INTO egress_usages (user_id, month, bytes, updated_at)
+VALUES (?, ?, ?, NOW())
+ON CONFLICT (user_id, month)
+DO UPDATE SET bytes = egress_usages.bytes + EXCLUDED.bytes, updated_at = NOW()`,
+		ownerID, MonthStart(time.Now()), bytes).Error
+}
+
+// MonthlyEgress returns the bytes served from the owner's files this month.
+func MonthlyEgress(db *gorm.DB, ownerID uuid.UUID) (int64, error) {
+	var bytes int64
+	err := db.Table("egress_usages").
+		Where("user_id = ? AND month = ?", ownerID, MonthStart(time.Now())).
+		Select("COALESCE(SUM(bytes), 0)").
+		Scan(&bytes).Error
+	return bytes, err
+}
+
+// EgressBudgetExceeded reports whether the owner has used up the monthly
+// budget. It is always false when no budget is configured.
+func EgressBudgetExceeded(db *gorm.DB, ownerID uuid.UUID) (bool, error) {
+	if MonthlyEgressBudget <= 0 {
+		return false, nil
+	}
+	used, err := MonthlyEgress(db, ownerID)
+	if err != nil {
+		return false, err
+	}
+	return used >= MonthlyEgressBudget, nil
+}
@@ -0,0 +1,114 @@
+package throttle
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Byte rates are read from the environment once, in bytes per second. Zero
+// disables that limit.
+//
+//	DOWNLOAD_BYTES_PER_SEC_PER_CONN  each download stream
+//	DOWNLOAD_BYTES_PER_SEC_PER_USER  all downloads by one user or public link
+//	UPLOAD_BYTES_PER_SEC_PER_CONN    each upload stream
+//	UPLOAD_BYTES_PER_SEC_PER_USER    all uploads by one user
+//	MONTHLY_EGRESS_BUDGET_BYTES      bytes an owner's files may serve per month
+const (
+	mib = 1 << 20
+	gib = 1 << 30
+)
+
+var (
+	DownloadPerConn = envInt("DOWNLOAD_BYTES_PER_SEC_PER_CONN", 10*mib)
+	DownloadPerUser = envInt("DOWNLOAD_BYTES_PER_SEC_PER_USER", 25*mib)
+	UploadPerConn   = envInt("UPLOAD_BYTES_PER_SEC_PER_CONN", 10*mib)
+	UploadPerUser   = envInt("UPLOAD_BYTES_PER_SEC_PER_USER", 25*mib)
+
+	MonthlyEgressBudget = envInt("MONTHLY_EGRESS_BUDGET_BYTES", 0)
+)
+
+// chunk is the burst size of every limiter and so the largest single read.
+const chunk = 64 * 1024
+
+// NewLimiter returns a limiter for bytesPerSec, or nil when it is zero.
+func NewLimiter(bytesPerSec int64) *rate.Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), chunk)
+}
+
+type sharedLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Pool hands out one shared limiter per key, so concurrent streams for the
+// same user draw from the same budget.
+type Pool struct {
+	bytesPerSec int64
+	mu          sync.Mutex
+	limiters    map[string]*sharedLimiter
+}
+
+func NewPool(bytesPerSec int64) *Pool {
+	p := &Pool{bytesPerSec: bytesPerSec, limiters: make(map[string]*sharedLimiter)}
+	if bytesPerSec > 0 {
+		go p.cleanup()
+	}
+	return p
+}
+
+// Get returns the shared limiter for key, or nil when the pool is unlimited.
+func (p *Pool) Get(key string) *rate.Limiter {
+	if p.bytesPerSec <= 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sl, ok := p.limiters[key]
+	if !ok {
+		sl = &sharedLimiter{limiter: NewLimiter(p.bytesPerSec)}
+		p.limiters[key] = sl
+	}
+	sl.lastSeen = time.Now()
+	return sl.limiter
+}
+
+func (p *Pool) cleanup() {
+	for {
+		time.Sleep(time.Minute)
+		p.mu.Lock()
+		for key, sl := range p.limiters {
+			if time.Since(sl.lastSeen) > 10*time.Minute {
+				delete(p.limiters, key)
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+var (
+	DownloadPool = NewPool(DownloadPerUser)
+	UploadPool   = NewPool(UploadPerUser)
+)
+
+func envInt(key string, def int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		log.Printf("⚠️  Ignoring %s=%q, expected a non-negative integer", key, value)
+		return def
+	}
+	return n
+}
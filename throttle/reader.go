@@ -0,0 +1,71 @@
+package throttle
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// Reader limits how fast an underlying reader can be drained. Every read
+// waits on all of its limiters, so a stream is held to the slowest of its
+// per-connection and shared per-user budgets.
+type Reader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*rate.Limiter
+	n        int64
+}
+
+// NewReader wraps r. Nil limiters are ignored, so unlimited budgets can be
+// passed straight through.
+func NewReader(ctx context.Context, r io.Reader, limiters ...*rate.Limiter) *Reader {
+	t := &Reader{ctx: ctx, r: r}
+	for _, l := range limiters {
+		if l != nil {
+			t.limiters = append(t.limiters, l)
+		}
+	}
+	return t
+}
+
+func (t *Reader) Read(p []byte) (int, error) {
+	// WaitN fails for requests larger than the burst, so never read more
+	// than the smallest burst in one go.
+	for _, l := range t.limiters {
+		if b := l.Burst(); b > 0 && len(p) > b {
+			p = p[:b]
+		}
+	}
+
+	n, err := t.r.Read(p)
+	t.n += int64(n)
+	if n > 0 {
+		for _, l := range t.limiters {
+			if waitErr := l.WaitN(t.ctx, n); waitErr != nil {
+				return n, waitErr
+			}
+		}
+	}
+	return n, err
+}
+
+// BytesRead reports how many bytes have passed through the reader.
+func (t *Reader) BytesRead() int64 {
+	return t.n
+}
+
+// ReadCloser is a Reader that closes the underlying stream, for wrapping
+// request bodies.
+type ReadCloser struct {
+	*Reader
+	c io.Closer
+}
+
+func NewReadCloser(ctx context.Context, rc io.ReadCloser, limiters ...*rate.Limiter) *ReadCloser {
+	return &ReadCloser{Reader: NewReader(ctx, rc, limiters...), c: rc}
+}
+
+func (t *ReadCloser) Close() error {
+	return t.c.Close()
+}
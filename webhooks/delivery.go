@@ -0,0 +1,215 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+)
+
+const (
+	// MaxAttempts is how many times a delivery is tried before it is
+	// marked failed.
+	MaxAttempts = 8
+
+	batchSize   = 50
+	concurrency = 8
+
+	// claimLease pushes claimed deliveries into the future so another
+	// instance doesn't pick them up while they are in flight.
+	claimLease = 2 * time.Minute
+
+	maxResponseBody = 1024
+)
+
+var client = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: 5 * time.Second, Control: blockPrivateAddresses}).DialContext,
+	},
+	// A redirect is reported as the delivery's response instead of followed.
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// blockPrivateAddresses stops webhooks being pointed at the server's own
+// network. WEBHOOK_ALLOW_PRIVATE=true lifts it for local testing.
+func blockPrivateAddresses(network, address string, _ syscall.RawConn) error {
+	if os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("webhook address %s is not allowed", host)
+	}
+	return nil
+}
+
+// DeliverDue sends every delivery whose next attempt is due and returns how
+// many were attempted.
+func DeliverDue() int {
+	total := 0
+	for {
+		var deliveries []models.WebhookDelivery
+		if err := initializers.DB.Raw(`
+UPDATE webhook_deliveries SET next_attempt_at = ?
+WHERE id IN (
+	SELECT id FROM webhook_deliveries
+	WHERE status = ? AND next_attempt_at <= ?
+	ORDER BY next_attempt_at
+	LIMIT ?
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`, time.Now().Add(claimLease), models.WebhookDeliveryPending, time.Now(), batchSize).
+			Scan(&deliveries).Error; err != nil {
+			log.Printf("Error claiming webhook deliveries: %v", err)
+			return total
+		}
+		if len(deliveries) == 0 {
+			return total
+		}
+
+		hooks, err := loadWebhooks(deliveries)
+		if err != nil {
+			log.Printf("Error loading webhooks: %v", err)
+			return total
+		}
+
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, concurrency)
+		for i := range deliveries {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(d *models.WebhookDelivery) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				attempt(d, hooks[d.WebhookID])
+			}(&deliveries[i])
+		}
+		wg.Wait()
+
+		total += len(deliveries)
+		if len(deliveries) < batchSize {
+			return total
+		}
+	}
+}
+
+func loadWebhooks(deliveries []models.WebhookDelivery) (map[uuid.UUID]*models.Webhook, error) {
+	ids := make([]uuid.UUID, 0, len(deliveries))
+	for _, d := range deliveries {
+		ids = append(ids, d.WebhookID)
+	}
+
+	var hooks []models.Webhook
+	if err := initializers.DB.Where("id IN ?", ids).Find(&hooks).Error; err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uuid.UUID]*models.Webhook, len(hooks))
+	for i := range hooks {
+		byID[hooks[i].ID] = &hooks[i]
+	}
+	return byID, nil
+}
+
+// attempt makes one delivery attempt and records its outcome.
+func attempt(d *models.WebhookDelivery, hook *models.Webhook) {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"attempts":        d.Attempts + 1,
+		"last_attempt_at": now,
+	}
+
+	status, body, err := send(d, hook)
+	if status != 0 {
+		updates["response_status"] = status
+	}
+	updates["response_body"] = body
+
+	switch {
+	case err == nil:
+		updates["status"] = models.WebhookDeliverySucceeded
+		updates["delivered_at"] = now
+		updates["last_error"] = ""
+	case hook == nil || !hook.Active || d.Attempts+1 >= MaxAttempts:
+		updates["status"] = models.WebhookDeliveryFailed
+		updates["last_error"] = err.Error()
+	default:
+		updates["next_attempt_at"] = now.Add(backoff(d.Attempts + 1))
+		updates["last_error"] = err.Error()
+	}
+
+	if err := initializers.DB.Model(&models.WebhookDelivery{}).Where("id = ?", d.ID).Updates(updates).Error; err != nil {
+		log.Printf("Error recording webhook delivery %s: %v", d.ID, err)
+	}
+}
+
+func send(d *models.WebhookDelivery, hook *models.Webhook) (int, string, error) {
+	if hook == nil {
+		return 0, "", fmt.Errorf("webhook was deleted")
+	}
+	if !hook.Active {
+		return 0, "", fmt.Errorf("webhook is disabled")
+	}
+
+	payload := []byte(d.Payload)
+	timestamp := time.Now().Unix()
+
+	ctx, cancel := context.WithTimeout(context.Background(), client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "FileShare-Webhooks/1.0")
+	req.Header.Set("X-FileShare-Event", d.Event)
+	req.Header.Set("X-FileShare-Delivery", d.ID.String())
+	req.Header.Set("X-FileShare-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-FileShare-Signature", Sign(hook.Secret, timestamp, payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, string(body), fmt.Errorf("endpoint responded with %d", resp.StatusCode)
+	}
+	return resp.StatusCode, string(body), nil
+}
+
+// backoff returns the wait after the given number of failed attempts:
+// 30s doubling up to 6h, with up to 20% jitter so retries from a burst of
+// failures spread out.
+func backoff(attempts int) time.Duration {
+	d := 30 * time.Second << (attempts - 1)
+	if d > 6*time.Hour || d <= 0 {
+		d = 6 * time.Hour
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
+}
@@ -0,0 +1,102 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+)
+
+// dispatchQueueSize is how many events are buffered before new ones are
+// dropped.
+const dispatchQueueSize = 1000
+
+type dispatchJob struct {
+	ownerID uuid.UUID
+	event   string
+	data    map[string]interface{}
+}
+
+var (
+	dispatchQueue   = make(chan dispatchJob, dispatchQueueSize)
+	dispatchDropped atomic.Int64
+
+	dispatchStartOnce sync.Once
+	dispatchStopOnce  sync.Once
+	dispatchDone      = make(chan struct{})
+)
+
+// Start runs the dispatcher in the background. Call Stop to drain and end it.
+func Start() {
+	dispatchStartOnce.Do(func() {
+		go runDispatcher()
+	})
+}
+
+// Stop queues the deliveries of every event already dispatched and stops
+// the dispatcher. It gives up when ctx ends.
+func Stop(ctx context.Context) error {
+	dispatchStopOnce.Do(func() {
+		close(dispatchQueue)
+	})
+	select {
+	case <-dispatchDone:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("webhook events not queued: %w", ctx.Err())
+	}
+}
+
+// Dispatch queues event for every active webhook of ownerID subscribed to
+// it. It never blocks the action that caused the event: the webhooks are
+// looked up in the background, and when the buffer is full the event is
+// dropped and logged.
+func Dispatch(ownerID *uuid.UUID, event string, data map[string]interface{}) {
+	if ownerID == nil {
+		return
+	}
+
+	defer func() {
+		// Dispatch after Stop sends on a closed channel.
+		if recover() != nil {
+			log.Printf("⚠️  Webhook dispatcher stopped, dropping %s for user %s", event, ownerID)
+		}
+	}()
+
+	select {
+	case dispatchQueue <- dispatchJob{ownerID: *ownerID, event: event, data: data}:
+	default:
+		if n := dispatchDropped.Add(1); n == 1 || n%100 == 0 {
+			log.Printf("⚠️  Webhook dispatch queue full, %d events dropped so far", n)
+		}
+	}
+}
+
+func runDispatcher() {
+	defer close(dispatchDone)
+	for job := range dispatchQueue {
+		dispatchNow(job)
+	}
+}
+
+func dispatchNow(job dispatchJob) {
+	var hooks []models.Webhook
+	if err := initializers.DB.
+		Where("user_id = ? AND active = ? AND events @> ?", job.ownerID, true, fmt.Sprintf("[%q]", job.event)).
+		Find(&hooks).Error; err != nil {
+		log.Printf("Failed to load webhooks for user %s: %v", job.ownerID, err)
+		return
+	}
+
+	for i := range hooks {
+		if _, err := Enqueue(&hooks[i], job.event, job.data); err != nil {
+			log.Printf("Failed to queue %s for webhook %s: %v", job.event, hooks[i].ID, err)
+		}
+	}
+}
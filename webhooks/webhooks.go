@@ -0,0 +1,130 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/basit/fileshare-backend/initializers"
+	"github.com/basit/fileshare-backend/models"
+)
+
+// Events a webhook can subscribe to.
+const (
+	EventFileUploaded   = "file.uploaded"
+	EventFileDownloaded = "file.downloaded"
+	EventFileExpired    = "file.expired"
+	EventFileDeleted    = "file.deleted"
+
+	// EventTest is only sent by the "send test event" mutation.
+	EventTest = "webhook.test"
+)
+
+var Events = []string{EventFileUploaded, EventFileDownloaded, EventFileExpired, EventFileDeleted}
+
+// Payload is the JSON body POSTed to a webhook.
+type Payload struct {
+	ID        string                 `json:"id"`
+	Event     string                 `json:"event"`
+	CreatedAt time.Time              `json:"createdAt"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// ValidEvent reports whether name is an event webhooks can subscribe to.
+func ValidEvent(name string) bool {
+	for _, e := range Events {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateURL checks that a webhook URL is absolute and uses HTTPS. Plain
+// HTTP is only accepted when WEBHOOK_ALLOW_HTTP=true, for local testing.
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL")
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if os.Getenv("WEBHOOK_ALLOW_HTTP") == "true" {
+			return nil
+		}
+	}
+	return fmt.Errorf("webhook URL must use https")
+}
+
+// NewSecret returns a random signing secret.
+func NewSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %v", err)
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// Sign returns the X-FileShare-Signature value for body sent at timestamp.
+// Receivers recompute HMAC-SHA256 over "<timestamp>.<body>" with their
+// secret and compare.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Enqueue queues one delivery of event to hook.
+func Enqueue(hook *models.Webhook, event string, data map[string]interface{}) (*models.WebhookDelivery, error) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+
+	id := uuid.New()
+	body, err := json.Marshal(Payload{
+		ID:        id.String(),
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload: %v", err)
+	}
+
+	delivery := models.WebhookDelivery{
+		ID:            id,
+		WebhookID:     hook.ID,
+		Event:         event,
+		Payload:       string(body),
+		Status:        models.WebhookDeliveryPending,
+		NextAttemptAt: time.Now(),
+	}
+	if err := initializers.DB.Create(&delivery).Error; err != nil {
+		return nil, fmt.Errorf("failed to queue delivery: %v", err)
+	}
+	return &delivery, nil
+}
+
+// FileData is the standard data block for file events.
+func FileData(file *models.File) map[string]interface{} {
+	return map[string]interface{}{
+		"fileId":      file.ID.String(),
+		"name":        file.OriginalName,
+		"size":        file.FileSize,
+		"contentType": file.ContentType,
+		"slug":        file.DownloadSlug,
+	}
+}